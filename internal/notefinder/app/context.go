@@ -46,10 +46,10 @@ func NewContext(interpreter *interpreter.Interpreter) *Context {
 		Requests:    make(chan common.Request, 1),
 	}
 
+	ctx.CommonStorage = db.NewCommonStorage()
 	ctx.Data = NewStore(ctx)
 	ctx.Worker = background.NewWorker(ctx, ctx.Data)
-	ctx.Consumer = background.NewConsumer(ctx)
-	ctx.CommonStorage = db.NewCommonStorage()
+	ctx.Consumer = background.NewConsumer(ctx, ctx.Data.Index())
 	ctx.Window = ui.NewWindow(ctx, ctx.Data, ctx.Application)
 	return ctx
 }
@@ -94,6 +94,7 @@ func (ctx *Context) Run() int {
 
 	ctx.Window.Show()
 	close(ctx.Requests)
+	ctx.CommonStorage.Close()
 	ctx.Interpreter.Destroy()
 	return 0
 }
@@ -110,6 +111,26 @@ func implByName(ctx *Context, name string, config map[string]string) types.Imple
 		return implementation.NewFileImplementation(config)
 	case "mozilla":
 		return implementation.NewMozillaImplementation(config)
+	case "mozilla-session":
+		return implementation.NewMozillaSessionImplementation(config)
+	case "chromium":
+		return implementation.NewChromiumImplementation(config)
+	case "netscape":
+		return implementation.NewNetscapeImplementation(config)
+	case "markdown":
+		return implementation.NewMarkdownImplementation(config)
+	case "todotxt":
+		return implementation.NewTodoTxtImplementation(config)
+	case "ical":
+		return implementation.NewICalImplementation(config)
+	case "org":
+		return implementation.NewOrgImplementation(config)
+	case "tomboy":
+		return implementation.NewTomboyImplementation(config)
+	case "zim":
+		return implementation.NewZimImplementation(config)
+	case "joplin":
+		return implementation.NewJoplinImplementation(config)
 	case "google":
 		return implementation.NewGoogleImplementation(config)
 	default:
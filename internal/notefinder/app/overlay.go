@@ -0,0 +1,186 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"notefinder/internal/notefinder/db"
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+User metadata that the notebook implementation cannot store itself (or at
+all, for read-only notebooks) is kept in the common storage and merged onto
+notes every time they are loaded.
+*/
+const (
+	overlayTags  = "tags"
+	overlayFlags = "flags"
+	// Flags the user cleared although the notebook sets them
+	overlayClearedFlags = "cleared_flags"
+	overlayAnnotation   = "annotation"
+)
+
+var overlayFlagProperties = map[string]uint32{
+	"Archived": types.FlagArchived,
+	"Notify":   types.FlagNotify,
+	"Starred":  types.FlagStarred,
+}
+
+func (self *Store) ApplyOverlay(note *types.Note) {
+	props := self.context.CommonStorage.GetAll(note)
+
+	if tags, ok := props[overlayTags]; ok {
+		for _, tag := range splitTags(tags) {
+			if !slices.Contains(note.Tags, tag) {
+				note.Tags = append(note.Tags, tag)
+			}
+		}
+	}
+
+	set, cleared := overlayFlagValue(props[overlayFlags]), overlayFlagValue(props[overlayClearedFlags])
+	for _, flag := range overlayFlagProperties {
+		if set&flag != 0 {
+			note.SetFlag(flag)
+		} else if cleared&flag != 0 {
+			note.UnsetFlag(flag)
+		}
+	}
+
+	if annotation, ok := props[overlayAnnotation]; ok {
+		note.Annotation = annotation
+	}
+}
+
+// UpdateNote saves changes to the note. Everything the notebook cannot store
+// goes to the overlay, so read-only notes can still be tagged, starred and
+// annotated.
+func (self *Store) UpdateNote(oldNote *types.Note, newNote *types.Note) error {
+	notebook := oldNote.Source
+	if notebook == nil {
+		return errors.New("note does not belong to any notebook")
+	}
+
+	readOnly := oldNote.FlagIsSet(types.FlagReadOnly)
+	if readOnly && (newNote.Title != oldNote.Title || newNote.Body != oldNote.Body) {
+		return errors.New("the note is read-only, only its tags, flags and annotation can be changed")
+	}
+	supported := notebook.SupportedProperties()
+	canStore := func(property string) bool {
+		return !readOnly && bool(supported[property])
+	}
+
+	// Implementations may replace newNote with what they saved, so the
+	// values for the overlay are taken before saving
+	storage := self.context.CommonStorage
+	var addedTags []string
+	if !canStore("Tags") {
+		// Tags coming from the notebook itself cannot be removed, so only
+		// keep track of the ones added by the user
+		var stored []string
+		if tags := storage.Get(oldNote, overlayTags); tags != nil {
+			stored = splitTags(*tags)
+		}
+		own := make([]string, 0)
+		for _, tag := range oldNote.Tags {
+			if !slices.Contains(stored, tag) {
+				own = append(own, tag)
+			}
+		}
+		addedTags = make([]string, 0)
+		for _, tag := range newNote.Tags {
+			if !slices.Contains(own, tag) {
+				addedTags = append(addedTags, tag)
+			}
+		}
+	}
+
+	// Only flags the user changed are kept, the ones the notebook derives
+	// itself, e.g. Notify for a due date, must follow the notebook
+	var set, cleared uint32
+	if value := storage.Get(oldNote, overlayFlags); value != nil {
+		set = overlayFlagValue(*value)
+	}
+	if value := storage.Get(oldNote, overlayClearedFlags); value != nil {
+		cleared = overlayFlagValue(*value)
+	}
+	for property, flag := range overlayFlagProperties {
+		switch {
+		case canStore(property):
+			set &^= flag
+			cleared &^= flag
+		case newNote.FlagIsSet(flag) && !oldNote.FlagIsSet(flag):
+			set |= flag
+			cleared &^= flag
+		case !newNote.FlagIsSet(flag) && oldNote.FlagIsSet(flag):
+			set &^= flag
+			cleared |= flag
+		}
+	}
+	annotation := newNote.Annotation
+
+	if !readOnly {
+		if err := notebook.UpdateData(oldNote, newNote); err != nil {
+			return err
+		}
+	}
+
+	if addedTags != nil {
+		setOverlay(storage, newNote, overlayTags, strings.Join(addedTags, "\n"))
+	}
+	setOverlay(storage, newNote, overlayFlags, formatOverlayFlags(set))
+	setOverlay(storage, newNote, overlayClearedFlags, formatOverlayFlags(cleared))
+	if !canStore("Annotation") {
+		setOverlay(storage, newNote, overlayAnnotation, annotation)
+	}
+
+	// Implementations may assign a new UUID when saving, e.g. a renamed file
+	if newNote.UUID != oldNote.UUID {
+		for _, key := range []string{overlayTags, overlayFlags, overlayClearedFlags,
+			overlayAnnotation} {
+			storage.Set(oldNote, key, nil)
+		}
+	}
+	self.ApplyOverlay(newNote)
+
+	return nil
+}
+
+func setOverlay(storage *db.CommonStorage, note *types.Note, key string, value string) {
+	if value == "" {
+		storage.Set(note, key, nil)
+		return
+	}
+	storage.Set(note, key, &value)
+}
+
+func overlayFlagValue(in string) uint32 {
+	if in == "" {
+		return 0
+	}
+	value, err := strconv.ParseUint(in, 10, 32)
+	if err != nil {
+		log.Println(err)
+	}
+	return uint32(value)
+}
+
+func formatOverlayFlags(flags uint32) string {
+	if flags == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(flags), 10)
+}
+
+func splitTags(in string) []string {
+	ret := make([]string, 0)
+	for _, tag := range strings.Split(in, "\n") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			ret = append(ret, tag)
+		}
+	}
+	return ret
+}
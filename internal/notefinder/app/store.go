@@ -1,23 +1,40 @@
 package app
 
 import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"notefinder/internal/notefinder/background"
+	"notefinder/internal/notefinder/search"
 	"notefinder/internal/notefinder/types"
 	"notefinder/internal/notefinder/util"
 )
 
+// Sites change their icons rarely, but they do
+const iconMaxAge = 30 * 24 * time.Hour
+
 type Store struct {
 	context   *Context
 	notebooks map[string]*types.Notebook
 	data      map[types.NoteKey]*types.Note
+	index     *background.Index
 	mx        sync.RWMutex
+	// URIs of icons being loaded
+	loadingIcons map[string]bool
+	iconsMx      sync.Mutex
 }
 
 func NewStore(ctx *Context) *Store {
 	return &Store{context: ctx, notebooks: readConfig(ctx),
-		data: make(map[types.NoteKey]*types.Note)}
+		data:         make(map[types.NoteKey]*types.Note),
+		index:        background.NewIndex(ctx.CommonStorage),
+		loadingIcons: make(map[string]bool)}
 }
 
 func (self *Store) Get(key types.NoteKey) (*types.Note, bool) {
@@ -36,14 +53,147 @@ func (self *Store) Put(key types.NoteKey, note *types.Note) {
 
 func (self *Store) Delete(key types.NoteKey) {
 	self.mx.Lock()
-	defer self.mx.Unlock()
 	delete(self.data, key)
+	self.mx.Unlock()
+	// Removing writes to the disk, queries should not wait for it
+	self.index.Remove(key)
 }
 
-func (self *Store) QueryStream(query *types.Query, out chan<- *types.Note) {
+func (self *Store) Index() *background.Index {
+	return self.index
+}
+
+// QueryStream sends matching notes to out and closes it when done. An error
+// is returned (and out is closed right away) if the query cannot be run.
+func (self *Store) QueryStream(query *types.Query, out chan<- *types.Note) error {
+	if query.Needle == "" {
+		self.queryMatching(query, out, nil, nil)
+		return nil
+	}
+
+	switch query.Method {
+	case types.QueryWithIndex:
+		if hits, ok := self.index.Search(query.Needle); ok {
+			self.queryIndex(query, hits, out)
+			return nil
+		}
+	case types.QueryRegexp:
+		pattern := query.Needle
+		if !query.MatchCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			close(out)
+			return fmt.Errorf("invalid regular expression: %w", err)
+		}
+		self.queryMatching(query, out, re.MatchString,
+			func(uri string) bool { return util.PdfMatchesRegexp(uri, re) })
+		return nil
+	case types.QueryComplex:
+		expr, err := search.Parse(query.Needle, query.MatchCase)
+		if err != nil {
+			close(out)
+			return fmt.Errorf("invalid query: %w", err)
+		}
+		self.queryExpr(query, expr, out)
+		return nil
+	}
+
+	needle := query.Needle
+	if !query.MatchCase {
+		needle = strings.ToLower(needle)
+	}
+	self.queryMatching(query, out,
+		func(value string) bool {
+			if !query.MatchCase {
+				value = strings.ToLower(value)
+			}
+			return strings.Contains(value, needle)
+		},
+		func(uri string) bool { return util.PdfMatchesPattern(uri, query.Needle) })
+	return nil
+}
+
+func (self *Store) queryIndex(query *types.Query, hits []background.IndexHit,
+	out chan<- *types.Note) {
+	self.mx.RLock()
+	defer self.mx.RUnlock()
+	defer close(out)
+
+	notes := make([]*types.Note, 0, len(hits))
+	for _, hit := range hits {
+		if query.Haystack != nil && query.Haystack != hit.Key.Notebook {
+			continue
+		}
+		note, ok := self.data[hit.Key]
+		if !ok {
+			continue
+		}
+		// The index is case-insensitive, so double-check against the note
+		if query.MatchCase && !slices.Contains(hit.Fields, "PDF content") &&
+			!matchesCase(note, query.Needle) {
+			continue
+		}
+
+		note.MatchingFields = hit.Fields
+		note.Score = hit.Score + background.Boost(note)
+		notes = append(notes, note)
+	}
+
+	sort.SliceStable(notes, func(i, j int) bool { return notes[i].Score > notes[j].Score })
+	for _, note := range notes {
+		out <- note
+	}
+}
+
+func (self *Store) queryExpr(query *types.Query, expr search.Expr,
+	out chan<- *types.Note) {
+	self.mx.RLock()
+	defer self.mx.RUnlock()
+	defer close(out)
+
+	needle := strings.Join(search.Terms(expr), " ")
+	for key, note := range self.data {
+		if query.Haystack != nil && query.Haystack != key.Notebook {
+			continue
+		}
+		if ok, fields := search.Match(expr, note); ok {
+			note.MatchingFields = fields
+			note.Score = self.index.Score(key, needle) + background.Boost(note)
+			out <- note
+		}
+	}
+}
+
+func matchesCase(note *types.Note, needle string) bool {
+	for _, word := range strings.Fields(needle) {
+		var found bool
+		for _, text := range note.SearchableText() {
+			if strings.Contains(text, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *Store) queryMatching(query *types.Query, out chan<- *types.Note,
+	match func(string) bool, matchPdf func(string) bool) {
 	self.mx.RLock()
 	defer self.mx.RUnlock()
 	var wg sync.WaitGroup
+	score := func(key types.NoteKey) float64 {
+		// Regular expressions are not words, there is nothing to rank by
+		if query.Method == types.QueryRegexp {
+			return 0
+		}
+		return self.index.Score(key, query.Needle)
+	}
 
 	for key, note := range self.data {
 		note.MatchingFields = make([]string, 0, 4)
@@ -51,45 +201,33 @@ func (self *Store) QueryStream(query *types.Query, out chan<- *types.Note) {
 			continue
 		}
 
-		if query.Needle == "" {
+		if match == nil {
+			note.Score = background.Boost(note)
 			out <- note
 			continue
 		}
 
 		var matchFound bool
-		for key, desc := range note.Mapping() {
-			if !desc.Searchable {
-				continue
-			}
-			value := desc.Ptr.(*string)
-			var haystack, needle string
-			if !query.MatchCase {
-				haystack = strings.ToLower(*value)
-				needle = strings.ToLower(query.Needle)
-			} else {
-				haystack = *value
-				needle = query.Needle
-			}
-			if strings.Contains(haystack, needle) {
-				note.MatchingFields = append(note.MatchingFields, key)
-
-				if !matchFound {
-					out <- note
-					matchFound = true
-				}
+		for field, text := range note.SearchableText() {
+			if match(text) {
+				note.MatchingFields = append(note.MatchingFields, field)
+				matchFound = true
 			}
 		}
-
-		if note.MimeType == "application/pdf" {
+		if matchFound {
+			note.Score = score(key) + background.Boost(note)
+			out <- note
+		} else if note.MimeType == "application/pdf" {
 			wg.Add(1)
-			go func(note *types.Note) {
+			go func(key types.NoteKey, note *types.Note) {
 				defer wg.Done()
 				// FIXME: memory leak!
-				if util.PdfMatchesPattern(note.URI, query.Needle) {
+				if matchPdf(note.URI) {
 					note.MatchingFields = append(note.MatchingFields, "PDF content")
+					note.Score = score(key) + background.Boost(note)
 					out <- note
 				}
-			}(note)
+			}(key, note)
 		}
 	}
 	go func() {
@@ -113,6 +251,48 @@ func (self *Store) Query(query *types.Query) []*types.Note {
 	return res
 }
 
+// NoteIcon returns the cached picture provided for the note by its notebook,
+// if any. Missing and outdated icons are loaded in the background and loaded
+// is called once a new one is in the cache. Icons are cached in the common
+// storage, including their absence and failures to load them.
+func (self *Store) NoteIcon(note *types.Note, loaded func()) []byte {
+	if note.Source == nil || note.URI == "" || !note.Source.HasIcons() {
+		return nil
+	}
+
+	storage := self.context.CommonStorage
+	data, modifiedAt, ok := storage.GetIcon(note.URI)
+	if ok && time.Since(modifiedAt) < iconMaxAge {
+		return data
+	}
+
+	self.iconsMx.Lock()
+	defer self.iconsMx.Unlock()
+	if self.loadingIcons[note.URI] {
+		return data
+	}
+	self.loadingIcons[note.URI] = true
+
+	go func() {
+		defer func() {
+			self.iconsMx.Lock()
+			delete(self.loadingIcons, note.URI)
+			self.iconsMx.Unlock()
+		}()
+
+		icon, _, err := note.Source.LoadIcon(note)
+		if err != nil {
+			log.Println(err)
+			icon = nil
+		}
+		storage.SetIcon(note.URI, icon)
+		if len(icon) > 0 && loaded != nil {
+			loaded()
+		}
+	}()
+	return data
+}
+
 func (self *Store) CreateNotebook(name string, notebook *types.Notebook) {
 	self.notebooks[name] = notebook
 }
@@ -2,13 +2,18 @@ package background
 
 import (
 	"fmt"
+	"hash/fnv"
+	"maps"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"unicode"
 
 	"notefinder/internal/notefinder/types"
+	"notefinder/internal/notefinder/util"
 )
 
 type BusReader interface {
@@ -17,11 +22,12 @@ type BusReader interface {
 
 type Consumer struct {
 	reader BusReader
+	index  *Index
 	mx     sync.Mutex
 }
 
-func NewConsumer(reader BusReader) *Consumer {
-	return &Consumer{reader: reader}
+func NewConsumer(reader BusReader, index *Index) *Consumer {
+	return &Consumer{reader: reader, index: index}
 }
 
 func (c *Consumer) Run() {
@@ -31,12 +37,39 @@ func (c *Consumer) Run() {
 			return
 		}
 
-		fmt.Println(note.Title)
-		for k, hits := range words(note) {
-			fmt.Printf("\"%s\": %d\n", k, hits)
+		key := types.NoteKey{Notebook: note.Source, UUID: note.UUID}
+		signature := indexSignature(note)
+		if c.index.Restore(key, signature) {
+			continue
+		}
+
+		fields := fieldWords(note)
+		if note.MimeType == "application/pdf" {
+			fields["PDF content"] = textWords(util.PdfText(note.URI))
 		}
-		fmt.Println("---------------------------------")
+		c.index.Add(key, signature, fields)
+	}
+}
+
+// Bump when stemming or word splitting changes to have everything indexed
+// again
+const indexVersion = 1
+
+// indexSignature identifies the content words of the note are taken from
+func indexSignature(note *types.Note) string {
+	text := note.SearchableText()
+	keys := slices.Sorted(maps.Keys(text))
+
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d\x00", indexVersion)
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s\x00%s\x00", key, text[key])
+	}
+	if note.MimeType == "application/pdf" {
+		// PDF content is read from the file
+		fmt.Fprintf(hash, "%s\x00%d", note.URI, note.ModifiedAt.Unix())
 	}
+	return strconv.FormatUint(hash.Sum64(), 16)
 }
 
 var (
@@ -48,34 +81,54 @@ var (
 
 func words(item *types.Note) map[string]int {
 	ret := make(map[string]int)
-	return ret
-	for _, desc := range item.Mapping() {
-		if !desc.Searchable {
-			continue
+	for _, hits := range fieldWords(item) {
+		for w, n := range hits {
+			ret[w] += n
 		}
-		value := desc.Ptr.(*string)
+	}
 
-		text, err := url.QueryUnescape(*value)
-		if err != nil {
-			continue
-		}
-		all := allWhiteSpace.Split(text, -1)
-		for _, w := range all {
-			cleanWord := strings.ToLower(allPunctuation.ReplaceAllString(w, ""))
-
-			onlyDigits := true
-			for _, r := range cleanWord {
-				if !unicode.IsDigit(r) {
-					onlyDigits = false
-					break
-				}
-			}
+	return ret
+}
 
-			if onlyDigits || len([]rune(cleanWord)) < 3 {
-				continue
+func fieldWords(item *types.Note) map[string]map[string]int {
+	ret := make(map[string]map[string]int)
+	for key, text := range item.SearchableText() {
+		ret[key] = textWords(text)
+	}
+
+	return ret
+}
+
+func textWords(text string) map[string]int {
+	ret := make(map[string]int)
+	for _, w := range cleanWords(text) {
+		ret[rules.Stem(w)]++
+	}
+
+	return ret
+}
+
+func cleanWords(text string) []string {
+	ret := make([]string, 0)
+	if unescaped, err := url.QueryUnescape(text); err == nil {
+		text = unescaped
+	}
+
+	for _, w := range allWhiteSpace.Split(text, -1) {
+		cleanWord := strings.ToLower(allPunctuation.ReplaceAllString(w, ""))
+
+		onlyDigits := true
+		for _, r := range cleanWord {
+			if !unicode.IsDigit(r) {
+				onlyDigits = false
+				break
 			}
-			ret[rules.Stem(cleanWord)]++
 		}
+
+		if onlyDigits || len([]rune(cleanWord)) < 3 {
+			continue
+		}
+		ret = append(ret, cleanWord)
 	}
 
 	return ret
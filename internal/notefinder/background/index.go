@@ -0,0 +1,292 @@
+package background
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"notefinder/internal/notefinder/types"
+)
+
+type IndexHit struct {
+	Key    types.NoteKey
+	Score  float64
+	Fields []string
+}
+
+// IndexStorage keeps indexed words of notes between runs, signature tells
+// which content the words were taken from
+type IndexStorage interface {
+	GetIndexEntry(key types.NoteKey, signature string) (map[string]map[string]int, bool)
+	SetIndexEntry(key types.NoteKey, signature string, fields map[string]map[string]int)
+	DeleteIndexEntry(key types.NoteKey)
+	PruneIndexEntries(notebook *types.Notebook, keep map[uint64]bool)
+	PruneIndexNotebooks(keep []string)
+}
+
+// Index is an inverted index from stemmed words to the notes (and the
+// fields of these notes) containing them. Changes are written through to
+// the storage, so notes which did not change since the last run are not
+// indexed again.
+type Index struct {
+	terms map[string]map[types.NoteKey]map[string]int
+	docs  map[types.NoteKey][]string
+	// Sorted terms for prefix lookups, may still have removed ones. Terms
+	// added since it was sorted wait in newTerms.
+	vocabulary []string
+	newTerms   []string
+	removed    int
+	storage    IndexStorage
+	// Notebooks whose stored words were checked against the loaded notes
+	pruned map[*types.Notebook]bool
+	mx     sync.RWMutex
+}
+
+// NewIndex creates an index kept in storage, nil storage keeps it in memory
+// only
+func NewIndex(storage IndexStorage) *Index {
+	return &Index{terms: make(map[string]map[types.NoteKey]map[string]int),
+		docs: make(map[types.NoteKey][]string), storage: storage,
+		pruned: make(map[*types.Notebook]bool)}
+}
+
+// Restore indexes the note with words kept in the storage, ok is false if
+// the storage has none for content with the given signature
+func (idx *Index) Restore(key types.NoteKey, signature string) bool {
+	if idx.storage == nil {
+		return false
+	}
+	fields, ok := idx.storage.GetIndexEntry(key, signature)
+	if !ok {
+		return false
+	}
+
+	idx.mx.Lock()
+	defer idx.mx.Unlock()
+	idx.add(key, fields)
+	return true
+}
+
+// Add (re)indexes note contents given as field name -> stemmed word -> hits
+func (idx *Index) Add(key types.NoteKey, signature string, fields map[string]map[string]int) {
+	idx.mx.Lock()
+	idx.add(key, fields)
+	idx.mx.Unlock()
+
+	if idx.storage != nil {
+		idx.storage.SetIndexEntry(key, signature, fields)
+	}
+}
+
+func (idx *Index) add(key types.NoteKey, fields map[string]map[string]int) {
+	idx.remove(key)
+
+	terms := make([]string, 0)
+	for field, hits := range fields {
+		for term, n := range hits {
+			postings, ok := idx.terms[term]
+			if !ok {
+				postings = make(map[types.NoteKey]map[string]int)
+				idx.terms[term] = postings
+				idx.newTerms = append(idx.newTerms, term)
+			}
+			if _, ok := postings[key]; !ok {
+				postings[key] = make(map[string]int)
+				terms = append(terms, term)
+			}
+			postings[key][field] += n
+		}
+	}
+	idx.docs[key] = terms
+}
+
+func (idx *Index) Remove(key types.NoteKey) {
+	idx.mx.Lock()
+	idx.remove(key)
+	idx.mx.Unlock()
+
+	if idx.storage != nil {
+		idx.storage.DeleteIndexEntry(key)
+	}
+}
+
+func (idx *Index) remove(key types.NoteKey) {
+	for _, term := range idx.docs[key] {
+		delete(idx.terms[term], key)
+		if len(idx.terms[term]) == 0 {
+			delete(idx.terms, term)
+			idx.removed++
+		}
+	}
+	delete(idx.docs, key)
+}
+
+// Prune drops stored words of notes which are not in the notebook anymore,
+// once per notebook. data has to be all notes of the notebook.
+func (idx *Index) Prune(notebook *types.Notebook, data map[uint64]*types.Note) {
+	if idx.storage == nil {
+		return
+	}
+	idx.mx.Lock()
+	done := idx.pruned[notebook]
+	idx.pruned[notebook] = true
+	idx.mx.Unlock()
+	if done {
+		return
+	}
+
+	keep := make(map[uint64]bool, len(data))
+	for uuid := range data {
+		keep[uuid] = true
+	}
+	idx.storage.PruneIndexEntries(notebook, keep)
+}
+
+// PruneNotebooks drops stored words of notebooks which are gone
+func (idx *Index) PruneNotebooks(notebooks map[string]*types.Notebook) {
+	if idx.storage == nil {
+		return
+	}
+	idx.storage.PruneIndexNotebooks(slices.Collect(maps.Keys(notebooks)))
+}
+
+// sortTerms merges terms added since the last search into the vocabulary
+// and drops removed ones
+func (idx *Index) sortTerms() {
+	idx.mx.Lock()
+	defer idx.mx.Unlock()
+	if len(idx.newTerms) == 0 && idx.removed == 0 {
+		return
+	}
+
+	slices.Sort(idx.newTerms)
+	vocabulary := make([]string, 0, len(idx.terms))
+	add := func(term string) {
+		if _, ok := idx.terms[term]; !ok {
+			return
+		}
+		// Terms removed and added again are in both lists
+		if n := len(vocabulary); n > 0 && vocabulary[n-1] == term {
+			return
+		}
+		vocabulary = append(vocabulary, term)
+	}
+	i, j := 0, 0
+	for i < len(idx.vocabulary) && j < len(idx.newTerms) {
+		if idx.vocabulary[i] <= idx.newTerms[j] {
+			add(idx.vocabulary[i])
+			i++
+		} else {
+			add(idx.newTerms[j])
+			j++
+		}
+	}
+	for ; i < len(idx.vocabulary); i++ {
+		add(idx.vocabulary[i])
+	}
+	for ; j < len(idx.newTerms); j++ {
+		add(idx.newTerms[j])
+	}
+
+	idx.vocabulary, idx.newTerms, idx.removed = vocabulary, nil, 0
+}
+
+// withPrefix returns terms starting with prefix
+func (idx *Index) withPrefix(prefix string) []string {
+	ret := make([]string, 0)
+	start, _ := slices.BinarySearch(idx.vocabulary, prefix)
+	for _, term := range idx.vocabulary[start:] {
+		if !strings.HasPrefix(term, prefix) {
+			break
+		}
+		if _, ok := idx.terms[term]; ok {
+			ret = append(ret, term)
+		}
+	}
+	return ret
+}
+
+// Search returns notes containing all words of the needle, best matches
+// first. The last word is also treated as a prefix so results show up while
+// the user is still typing. ok is false if the needle has no indexable words.
+func (idx *Index) Search(needle string) (res []IndexHit, ok bool) {
+	queryWords := cleanWords(needle)
+	if len(queryWords) == 0 {
+		return nil, false
+	}
+
+	idx.sortTerms()
+	idx.mx.RLock()
+	defer idx.mx.RUnlock()
+
+	hits := make(map[types.NoteKey]*IndexHit)
+	matched := make(map[types.NoteKey]int)
+	for i, word := range queryWords {
+		stem := rules.Stem(word)
+		candidates := []string{stem}
+		if i == len(queryWords)-1 {
+			for _, term := range idx.withPrefix(word) {
+				if term != stem {
+					candidates = append(candidates, term)
+				}
+			}
+		}
+
+		seen := make(map[types.NoteKey]bool)
+		for _, term := range candidates {
+			postings := idx.terms[term]
+			idf := idx.idf(term)
+			for key, fields := range postings {
+				hit, ok := hits[key]
+				if !ok {
+					hit = &IndexHit{Key: key, Fields: make([]string, 0, 4)}
+					hits[key] = hit
+				}
+				for field, n := range fields {
+					hit.Score += termScore(field, n, idf)
+					if !slices.Contains(hit.Fields, field) {
+						hit.Fields = append(hit.Fields, field)
+					}
+				}
+				if !seen[key] {
+					seen[key] = true
+					matched[key]++
+				}
+			}
+		}
+	}
+
+	res = make([]IndexHit, 0, len(hits))
+	for key, hit := range hits {
+		if matched[key] == len(queryWords) {
+			res = append(res, *hit)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Score > res[j].Score })
+
+	return res, true
+}
+
+// Score computes relevance of an already matched note to the needle, in the
+// same units as IndexHit.Score.
+func (idx *Index) Score(key types.NoteKey, needle string) float64 {
+	idx.mx.RLock()
+	defer idx.mx.RUnlock()
+
+	var score float64
+	for _, word := range cleanWords(needle) {
+		term := rules.Stem(word)
+		idf := idx.idf(term)
+		for field, n := range idx.terms[term][key] {
+			score += termScore(field, n, idf)
+		}
+	}
+	return score
+}
+
+func (idx *Index) idf(term string) float64 {
+	return math.Log(1 + float64(len(idx.docs))/float64(len(idx.terms[term])+1))
+}
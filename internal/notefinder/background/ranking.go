@@ -0,0 +1,49 @@
+package background
+
+import (
+	"math"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+var (
+	fieldWeights = map[string]float64{
+		"Title":       4,
+		"Body":        1,
+		"PDF content": 0.5,
+	}
+)
+
+const (
+	starredBoost    = 2.0
+	recencyBoost    = 2.0
+	recencyHalfLife = 30 * 24 * time.Hour
+)
+
+// termScore dampens term frequency so that a single title hit outweighs a
+// word repeated all over the body
+func termScore(field string, hits int, idf float64) float64 {
+	weight, ok := fieldWeights[field]
+	if !ok {
+		weight = 1
+	}
+	return weight * (1 + math.Log(float64(hits))) * idf
+}
+
+// Boost is the query-independent part of the score: starred and recently
+// modified notes go first.
+func Boost(note *types.Note) float64 {
+	var score float64
+	if note.FlagIsSet(types.FlagStarred) {
+		score += starredBoost
+	}
+	if !note.ModifiedAt.IsZero() {
+		age := time.Since(note.ModifiedAt)
+		if age < 0 {
+			age = 0
+		}
+		score += recencyBoost * math.Pow(0.5, float64(age)/float64(recencyHalfLife))
+	}
+	return score
+}
@@ -3,6 +3,7 @@ package background
 import (
 	"fmt"
 	"log"
+	"slices"
 	"sync"
 	"time"
 
@@ -26,11 +27,14 @@ type Store interface {
 	Get(types.NoteKey) (*types.Note, bool)
 	Delete(types.NoteKey)
 	Query(*types.Query) []*types.Note
+	ApplyOverlay(*types.Note)
+	Index() *Index
 }
 
 type Worker struct {
 	manager Manager
 	store   Store
+	watched []*types.Notebook
 	mx      sync.Mutex
 }
 
@@ -48,48 +52,96 @@ func (w *Worker) Run() {
 			implementation.NewMozillaImplementation(bookmarkConfig),
 			bookmarkConfig, types.NotebookAutoDiscovered))
 	}
+	for name, sessionFile := range implementation.GetMozillaSessionFiles() {
+		sessionConfig := map[string]string{"path": sessionFile}
+		name += " tabs"
+		w.store.CreateNotebook(name, types.NewNotebook(name,
+			implementation.NewMozillaSessionImplementation(sessionConfig),
+			sessionConfig, types.NotebookAutoDiscovered))
+	}
+	for name, bookmarkFile := range implementation.GetChromiumFiles() {
+		bookmarkConfig := map[string]string{"path": bookmarkFile}
+		w.store.CreateNotebook(name, types.NewNotebook(name,
+			implementation.NewChromiumImplementation(bookmarkConfig),
+			bookmarkConfig, types.NotebookAutoDiscovered))
+	}
+	for name, notesDir := range implementation.GetTomboyFiles() {
+		notesConfig := map[string]string{"path": notesDir}
+		w.store.CreateNotebook(name, types.NewNotebook(name,
+			implementation.NewTomboyImplementation(notesConfig),
+			notesConfig, types.NotebookAutoDiscovered))
+	}
+	for name, notebookDir := range implementation.GetZimFiles() {
+		notebookConfig := map[string]string{"path": notebookDir}
+		w.store.CreateNotebook(name, types.NewNotebook(name,
+			implementation.NewZimImplementation(notebookConfig),
+			notebookConfig, types.NotebookAutoDiscovered))
+	}
+	for name, databaseFile := range implementation.GetJoplinFiles() {
+		databaseConfig := map[string]string{"path": databaseFile}
+		w.store.CreateNotebook(name, types.NewNotebook(name,
+			implementation.NewJoplinImplementation(databaseConfig),
+			databaseConfig, types.NotebookAutoDiscovered))
+	}
+
+	w.store.Index().PruneNotebooks(w.store.GetNotebooks())
+
+	stop := make(chan struct{})
+	defer close(stop)
+	changes := make(chan notebookChanges)
+	// Notebooks whose watcher stopped, e.g. the path does not exist (yet)
+	// or inotify is out of watches, go back to polling
+	unwatched := make(chan *types.Notebook)
+	for _, notebook := range w.store.GetNotebooks() {
+		watcher, ok := notebook.Watcher()
+		if !ok {
+			continue
+		}
+		notebookChangeSets := make(chan *types.ChangeSet)
+		go func() {
+			err := watcher.Watch(notebookChangeSets, stop)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			log.Println(notebook.Name, "is not watched anymore:", err)
+			select {
+			case unwatched <- notebook:
+			case <-stop:
+			}
+		}()
+		go func() {
+			for {
+				select {
+				case changeSet := <-notebookChangeSets:
+					select {
+					case changes <- notebookChanges{notebook: notebook, changeSet: changeSet}:
+					case <-stop:
+						return
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+		w.watched = append(w.watched, notebook)
+	}
 
+	// Watched notebooks are only reloaded on explicit request
 	ticker := time.NewTicker(10 * time.Second)
-	doWork := func() {
+	doWork := func(all bool) {
 		w.mx.Lock()
 		defer w.mx.Unlock()
 		var wg sync.WaitGroup
-		notebooks := w.store.GetNotebooks()
-		wg.Add(len(notebooks))
-		for _, notebook := range notebooks {
+		for _, notebook := range w.store.GetNotebooks() {
+			if !all && slices.Contains(w.watched, notebook) {
+				continue
+			}
+			wg.Add(1)
 			go func() {
 				defer wg.Done()
-
-				var haveUpdates bool
-				data, err := notebook.LoadData()
-				if err != nil {
-					log.Println(err)
-					return
-				}
-
-				for _, oldItem := range w.store.Query(&types.Query{Haystack: notebook}) {
-					_, stillHave := data[oldItem.UUID]
-					if !stillHave {
-						haveUpdates = true
-						w.store.Delete(types.NoteKey{Notebook: notebook, UUID: oldItem.UUID})
-					}
-				}
-
-				for uuid, item := range data {
-					key := types.NoteKey{Notebook: notebook, UUID: uuid}
-					existingItem, ok := w.store.Get(key)
-					if ok && item.SameAs(existingItem) {
-						continue
-					}
-					item.Source = notebook
-
-					w.store.Put(key, item)
-					w.manager.WriteToBus(item)
-					haveUpdates = true
-				}
-				if haveUpdates {
-					w.manager.Refresh() // FIXME: check if this is thread-safe at all
-				}
+				w.loadNotebook(notebook)
 			}()
 		}
 		wg.Wait()
@@ -98,11 +150,22 @@ func (w *Worker) Run() {
 	for {
 		select {
 		case <-ticker.C:
-			doWork()
+			doWork(false)
+		case c := <-changes:
+			w.mx.Lock()
+			w.applyChanges(c.notebook, c.changeSet)
+			w.mx.Unlock()
+		case notebook := <-unwatched:
+			w.mx.Lock()
+			w.watched = slices.DeleteFunc(w.watched, func(other *types.Notebook) bool {
+				return other == notebook
+			})
+			w.loadNotebook(notebook)
+			w.mx.Unlock()
 		case req := <-w.manager.GetRequests():
 			switch req {
 			case common.RequestLoadData:
-				doWork()
+				doWork(true)
 			case common.RequestStop:
 				fmt.Println("received graceful shutdown request")
 				return
@@ -110,3 +173,96 @@ func (w *Worker) Run() {
 		}
 	}
 }
+
+type notebookChanges struct {
+	notebook  *types.Notebook
+	changeSet *types.ChangeSet
+}
+
+func (w *Worker) loadNotebook(notebook *types.Notebook) {
+	changeSet, ok, err := notebook.LoadChanges()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if ok && !changeSet.Reload {
+		w.applyChanges(notebook, changeSet)
+		return
+	}
+
+	var haveUpdates bool
+	data, err := notebook.LoadData()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, oldItem := range w.store.Query(&types.Query{Haystack: notebook}) {
+		_, stillHave := data[oldItem.UUID]
+		if !stillHave {
+			haveUpdates = true
+			w.store.Delete(types.NoteKey{Notebook: notebook, UUID: oldItem.UUID})
+		}
+	}
+
+	for uuid, item := range data {
+		if w.putItem(notebook, uuid, item) {
+			haveUpdates = true
+		}
+	}
+	w.store.Index().Prune(notebook, data)
+	if haveUpdates {
+		w.manager.Refresh() // FIXME: check if this is thread-safe at all
+	}
+}
+
+func (w *Worker) applyChanges(notebook *types.Notebook, changeSet *types.ChangeSet) {
+	if changeSet.Reload {
+		w.loadNotebook(notebook)
+		return
+	}
+
+	var haveUpdates bool
+	if changeSet.Snapshot {
+		for _, oldItem := range w.store.Query(&types.Query{Haystack: notebook}) {
+			if _, stillHave := changeSet.Added[oldItem.UUID]; !stillHave {
+				changeSet.Deleted = append(changeSet.Deleted, oldItem.UUID)
+			}
+		}
+	}
+	for _, uuid := range changeSet.Deleted {
+		key := types.NoteKey{Notebook: notebook, UUID: uuid}
+		if _, ok := w.store.Get(key); ok {
+			w.store.Delete(key)
+			haveUpdates = true
+		}
+	}
+	for _, items := range []map[uint64]*types.Note{changeSet.Added, changeSet.Changed} {
+		for uuid, item := range items {
+			if w.putItem(notebook, uuid, item) {
+				haveUpdates = true
+			}
+		}
+	}
+	if changeSet.Snapshot {
+		w.store.Index().Prune(notebook, changeSet.Added)
+	}
+	if haveUpdates {
+		w.manager.Refresh()
+	}
+}
+
+// putItem stores the item unless the very same one is already there
+func (w *Worker) putItem(notebook *types.Notebook, uuid uint64, item *types.Note) bool {
+	key := types.NoteKey{Notebook: notebook, UUID: uuid}
+	item.Source = notebook
+	w.store.ApplyOverlay(item)
+	existingItem, ok := w.store.Get(key)
+	if ok && item.SameAs(existingItem) {
+		return false
+	}
+
+	w.store.Put(key, item)
+	w.manager.WriteToBus(item)
+	return true
+}
@@ -2,6 +2,15 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
 	_ "github.com/mattn/go-sqlite3"
 
 	"notefinder/internal/notefinder/types"
@@ -10,19 +19,475 @@ import (
 type CommonStorage struct {
 	db    *sql.DB
 	cache map[types.NoteKey]map[string]string
+	icons map[string]cachedIcon
+	mx    sync.Mutex
+}
+
+type cachedIcon struct {
+	data       []byte
+	modifiedAt time.Time
 }
 
 var (
 	commonStorageRelPath = ".local/share/Notefinder/storage.db"
 )
 
+/*
+Each entry upgrades the schema by one version, PRAGMA user_version holds the
+number of migrations already applied. Never edit existing entries, append
+new ones.
+*/
+var migrations = []string{
+	`create table properties (
+		notebook text not null,
+		uuid integer not null,
+		key text not null,
+		value text not null,
+		modified_at integer not null,
+		primary key (notebook, uuid, key)
+	)`,
+	`create table icons (
+		uri text primary key,
+		data blob not null,
+		modified_at integer not null
+	)`,
+	`create table index_docs (
+		notebook text not null,
+		uuid integer not null,
+		signature text not null,
+		primary key (notebook, uuid)
+	);
+	create table index_postings (
+		notebook text not null,
+		uuid integer not null,
+		field text not null,
+		term text not null,
+		hits integer not null,
+		primary key (notebook, uuid, field, term)
+	)`,
+}
+
 func NewCommonStorage() *CommonStorage {
-	return &CommonStorage{}
+	self := &CommonStorage{cache: make(map[types.NoteKey]map[string]string),
+		icons: make(map[string]cachedIcon)}
+
+	user, _ := user.Current()
+	path := filepath.Join(user.HomeDir, commonStorageRelPath)
+	db, err := openStorage(path)
+	if err != nil {
+		// Keep working with in-memory cache only
+		log.Println(err)
+		return self
+	}
+	self.db = db
+
+	return self
+}
+
+func openStorage(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if err = migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("pragma user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("storage migration %d failed: %w", version+1, err)
+		}
+		if _, err = tx.Exec(fmt.Sprintf("pragma user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *CommonStorage) Close() error {
+	if self.db == nil {
+		return nil
+	}
+	return self.db.Close()
 }
 
+// Set stores value under key for the note, nil value removes the key
 func (self *CommonStorage) Set(note *types.Note, key string, value *string) {
+	if note.Source == nil {
+		return
+	}
+
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	props, err := self.load(note)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if self.db != nil {
+		if value == nil {
+			_, err = self.db.Exec(`delete from properties
+				where notebook = ? and uuid = ? and key = ?`,
+				note.Source.Name, int64(note.UUID), key)
+		} else {
+			_, err = self.db.Exec(`insert into properties
+				(notebook, uuid, key, value, modified_at) values (?, ?, ?, ?, ?)
+				on conflict (notebook, uuid, key) do update
+				set value = excluded.value, modified_at = excluded.modified_at`,
+				note.Source.Name, int64(note.UUID), key, *value, time.Now().Unix())
+		}
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	if value == nil {
+		delete(props, key)
+	} else {
+		props[key] = *value
+	}
 }
 
 func (self *CommonStorage) Get(note *types.Note, key string) *string {
-	return nil
+	if note.Source == nil {
+		return nil
+	}
+
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	props, err := self.load(note)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	value, ok := props[key]
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+// GetAll returns a copy of all the properties stored for the note
+func (self *CommonStorage) GetAll(note *types.Note) map[string]string {
+	ret := make(map[string]string)
+	if note.Source == nil {
+		return ret
+	}
+
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	props, err := self.load(note)
+	if err != nil {
+		log.Println(err)
+		return ret
+	}
+
+	for k, v := range props {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (self *CommonStorage) load(note *types.Note) (map[string]string, error) {
+	cacheKey := types.NoteKey{Notebook: note.Source, UUID: note.UUID}
+	if props, ok := self.cache[cacheKey]; ok {
+		return props, nil
+	}
+
+	props := make(map[string]string)
+	if self.db != nil {
+		rows, err := self.db.Query(`select key, value from properties
+			where notebook = ? and uuid = ?`, note.Source.Name, int64(note.UUID))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var key, value string
+			if err := rows.Scan(&key, &value); err != nil {
+				return nil, err
+			}
+			props[key] = value
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	self.cache[cacheKey] = props
+	return props, nil
+}
+
+// GetIcon returns the icon cached for the URI and when it was cached. Empty
+// data means the URI is known to have no icon.
+func (self *CommonStorage) GetIcon(uri string) ([]byte, time.Time, bool) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	if icon, ok := self.icons[uri]; ok {
+		return icon.data, icon.modifiedAt, true
+	}
+	if self.db == nil {
+		return nil, time.Time{}, false
+	}
+
+	var icon cachedIcon
+	var modifiedAt int64
+	err := self.db.QueryRow(`select data, modified_at from icons where uri = ?`,
+		uri).Scan(&icon.data, &modifiedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		return nil, time.Time{}, false
+	}
+	icon.modifiedAt = time.Unix(modifiedAt, 0)
+
+	self.icons[uri] = icon
+	return icon.data, icon.modifiedAt, true
+}
+
+func (self *CommonStorage) SetIcon(uri string, data []byte) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	icon := cachedIcon{data: data, modifiedAt: time.Now()}
+	if icon.data == nil {
+		icon.data = []byte{}
+	}
+	if self.db != nil {
+		_, err := self.db.Exec(`insert into icons (uri, data, modified_at) values (?, ?, ?)
+			on conflict (uri) do update
+			set data = excluded.data, modified_at = excluded.modified_at`,
+			uri, icon.data, icon.modifiedAt.Unix())
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	self.icons[uri] = icon
+}
+
+// GetIndexEntry returns the words indexed for the note as field name ->
+// stemmed word -> hits, ok is false unless they were indexed from content
+// with the given signature
+func (self *CommonStorage) GetIndexEntry(key types.NoteKey, signature string) (map[string]map[string]int, bool) {
+	if self.db == nil || key.Notebook == nil {
+		return nil, false
+	}
+
+	var stored string
+	err := self.db.QueryRow(`select signature from index_docs
+		where notebook = ? and uuid = ?`, key.Notebook.Name, int64(key.UUID)).Scan(&stored)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		return nil, false
+	}
+	if stored != signature {
+		return nil, false
+	}
+
+	rows, err := self.db.Query(`select field, term, hits from index_postings
+		where notebook = ? and uuid = ?`, key.Notebook.Name, int64(key.UUID))
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	defer rows.Close()
+
+	fields := make(map[string]map[string]int)
+	for rows.Next() {
+		var field, term string
+		var hits int
+		if err := rows.Scan(&field, &term, &hits); err != nil {
+			log.Println(err)
+			return nil, false
+		}
+		if _, ok := fields[field]; !ok {
+			fields[field] = make(map[string]int)
+		}
+		fields[field][term] = hits
+	}
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	return fields, true
+}
+
+// SetIndexEntry replaces the words indexed for the note
+func (self *CommonStorage) SetIndexEntry(key types.NoteKey, signature string, fields map[string]map[string]int) {
+	if self.db == nil || key.Notebook == nil {
+		return
+	}
+
+	err := self.inTransaction(func(tx *sql.Tx) error {
+		if err := deleteIndexEntry(tx, key); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`insert into index_docs (notebook, uuid, signature) values (?, ?, ?)`,
+			key.Notebook.Name, int64(key.UUID), signature)
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.Prepare(`insert into index_postings
+			(notebook, uuid, field, term, hits) values (?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for field, hits := range fields {
+			for term, n := range hits {
+				_, err := stmt.Exec(key.Notebook.Name, int64(key.UUID), field, term, n)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (self *CommonStorage) DeleteIndexEntry(key types.NoteKey) {
+	if self.db == nil || key.Notebook == nil {
+		return
+	}
+
+	err := self.inTransaction(func(tx *sql.Tx) error {
+		return deleteIndexEntry(tx, key)
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// PruneIndexEntries drops words indexed for notes of the notebook which
+// are not in keep
+func (self *CommonStorage) PruneIndexEntries(notebook *types.Notebook, keep map[uint64]bool) {
+	if self.db == nil {
+		return
+	}
+
+	rows, err := self.db.Query(`select uuid from index_docs where notebook = ?`, notebook.Name)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	gone := make([]uint64, 0)
+	for rows.Next() {
+		var uuid int64
+		if err := rows.Scan(&uuid); err != nil {
+			rows.Close()
+			log.Println(err)
+			return
+		}
+		if !keep[uint64(uuid)] {
+			gone = append(gone, uint64(uuid))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return
+	}
+	if len(gone) == 0 {
+		return
+	}
+
+	err = self.inTransaction(func(tx *sql.Tx) error {
+		for _, uuid := range gone {
+			if err := deleteIndexEntry(tx, types.NoteKey{Notebook: notebook, UUID: uuid}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// PruneIndexNotebooks drops words indexed for notebooks not in keep
+func (self *CommonStorage) PruneIndexNotebooks(keep []string) {
+	if self.db == nil {
+		return
+	}
+
+	err := self.inTransaction(func(tx *sql.Tx) error {
+		// No more than a handful of notebooks, they fit in a single statement
+		names := strings.TrimSuffix(strings.Repeat("?, ", len(keep)), ", ")
+		args := make([]any, 0, len(keep))
+		for _, name := range keep {
+			args = append(args, name)
+		}
+		for _, table := range []string{"index_docs", "index_postings"} {
+			_, err := tx.Exec(`delete from `+table+` where notebook not in (`+names+`)`, args...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func deleteIndexEntry(tx *sql.Tx, key types.NoteKey) error {
+	_, err := tx.Exec(`delete from index_docs where notebook = ? and uuid = ?`,
+		key.Notebook.Name, int64(key.UUID))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`delete from index_postings where notebook = ? and uuid = ?`,
+		key.Notebook.Name, int64(key.UUID))
+	return err
+}
+
+func (self *CommonStorage) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
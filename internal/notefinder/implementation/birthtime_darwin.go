@@ -0,0 +1,19 @@
+//go:build darwin
+
+package implementation
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// birthTime returns file creation time if the filesystem keeps it and
+// modification time otherwise
+func birthTime(path string, info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Birthtimespec.Sec == 0 {
+		return info.ModTime()
+	}
+	return time.Unix(stat.Birthtimespec.Unix())
+}
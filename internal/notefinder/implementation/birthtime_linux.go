@@ -0,0 +1,21 @@
+//go:build linux
+
+package implementation
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// birthTime returns file creation time if the filesystem keeps it and
+// modification time otherwise
+func birthTime(path string, info os.FileInfo) time.Time {
+	var stat unix.Statx_t
+	err := unix.Statx(unix.AT_FDCWD, path, 0, unix.STATX_BTIME, &stat)
+	if err != nil || stat.Mask&unix.STATX_BTIME == 0 {
+		return info.ModTime()
+	}
+	return time.Unix(stat.Btime.Sec, int64(stat.Btime.Nsec))
+}
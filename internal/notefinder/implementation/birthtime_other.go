@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package implementation
+
+import (
+	"os"
+	"time"
+)
+
+func birthTime(path string, info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,228 @@
+package implementation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+Chrome, Chromium and their forks keep bookmarks as JSON in the Bookmarks file
+of every profile directory, times are in microseconds since 1601-01-01
+*/
+const (
+	webkitEpochOffset = 11644473600000000
+)
+
+type chromiumRoot struct {
+	// Relative to the home directory
+	path  string
+	label string
+}
+
+var chromiumRoots = []chromiumRoot{
+	{".config/google-chrome", "Chrome"},
+	{".config/chromium", "Chromium"},
+	{"snap/chromium/common/chromium", "Chromium Snap"},
+	{".var/app/org.chromium.Chromium/config/chromium", "Chromium Flatpak"},
+	{".var/app/com.google.Chrome/config/google-chrome", "Chrome Flatpak"},
+	{".config/BraveSoftware/Brave-Browser", "Brave"},
+	{".var/app/com.brave.Browser/config/BraveSoftware/Brave-Browser", "Brave Flatpak"},
+	{".config/vivaldi", "Vivaldi"},
+	{".config/microsoft-edge", "Edge"},
+	{"Library/Application Support/Google/Chrome", "Chrome"},
+	{"Library/Application Support/Chromium", "Chromium"},
+	{"Library/Application Support/BraveSoftware/Brave-Browser", "Brave"},
+	{"Library/Application Support/Vivaldi", "Vivaldi"},
+	{"Library/Application Support/Microsoft Edge", "Edge"},
+}
+
+type ChromiumImplementation struct {
+	path string
+}
+
+type chromiumNode struct {
+	Type         string         `json:"type"`
+	Name         string         `json:"name"`
+	URL          string         `json:"url"`
+	GUID         string         `json:"guid"`
+	DateAdded    string         `json:"date_added"`
+	DateModified string         `json:"date_modified"`
+	Children     []chromiumNode `json:"children"`
+}
+
+type chromiumBookmarks struct {
+	Roots map[string]json.RawMessage `json:"roots"`
+}
+
+func NewChromiumImplementation(config map[string]string) *ChromiumImplementation {
+	return &ChromiumImplementation{path: config["path"]}
+}
+
+func (self *ChromiumImplementation) CanWrite() (bool, error) {
+	return false, errors.New("Creating new bookmarks is not supported yet")
+}
+
+func (self *ChromiumImplementation) SupportedProperties() map[string]types.Writable {
+	return map[string]types.Writable{"Title": false, "URI": false}
+}
+
+func (self *ChromiumImplementation) LoadData() (map[uint64]*types.Note, error) {
+	data, err := os.ReadFile(self.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookmarks chromiumBookmarks
+	if err := json.Unmarshal(data, &bookmarks); err != nil {
+		return nil, fmt.Errorf("%s: %w", self.path, err)
+	}
+
+	notes := make(map[uint64]*types.Note)
+	for _, raw := range bookmarks.Roots {
+		// Roots also holds the sync metadata, which is not a folder
+		var root chromiumNode
+		if err := json.Unmarshal(raw, &root); err != nil || root.Type != "folder" {
+			continue
+		}
+		// Root folders such as "Bookmarks bar" are not worth a tag
+		for _, child := range root.Children {
+			addChromiumNode(notes, child, nil)
+		}
+	}
+
+	return notes, nil
+}
+
+func addChromiumNode(notes map[uint64]*types.Note, node chromiumNode, paths []string) {
+	switch node.Type {
+	case "folder":
+		paths = append(slices.Clone(paths), node.Name)
+		for _, child := range node.Children {
+			addChromiumNode(notes, child, paths)
+		}
+	case "url":
+		uuid := stringUUID(node.GUID)
+		if node.GUID == "" {
+			uuid = stringUUID(node.URL, node.DateAdded)
+		}
+
+		note := types.NewNote(uuid, node.Name)
+		note.SetFlag(types.FlagReadOnly)
+		note.URI = node.URL
+		note.Type = types.NoteTypeBookmark
+		note.Tags = slices.Clone(paths)
+		note.CreatedAt = webkitTime(node.DateAdded)
+		note.ModifiedAt = webkitTime(node.DateModified)
+		if note.ModifiedAt.IsZero() {
+			note.ModifiedAt = note.CreatedAt
+		}
+		notes[uuid] = note
+	}
+}
+
+// webkitTime converts microseconds since 1601-01-01, stored as a string
+func webkitTime(value string) time.Time {
+	microseconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || microseconds == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(microseconds - webkitEpochOffset)
+}
+
+// Watch reports a reload whenever the browser rewrites the bookmarks
+func (self *ChromiumImplementation) Watch(changes chan<- *types.ChangeSet, stop <-chan struct{}) error {
+	return watchModTime(self.path, changes, stop)
+}
+
+func (self *ChromiumImplementation) PutData(note *types.Note) error {
+	return errors.New("Creating bookmarks is not currently supported")
+}
+
+func (self *ChromiumImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	return errors.New("Editing bookmarks is not currently supported")
+}
+
+func (self *ChromiumImplementation) DeleteData(note *types.Note) error {
+	return errors.New("Deleting bookmarks is not currently supported")
+}
+
+// GetChromiumFiles returns Bookmarks files of all the profiles found, named
+// after the browser and the profile
+func GetChromiumFiles() map[string]string {
+	files := make(map[string]string)
+
+	user, _ := user.Current()
+	seen := make(map[string]bool)
+	for _, root := range chromiumRoots {
+		baseDir := filepath.Join(user.HomeDir, root.path)
+		items, err := os.ReadDir(baseDir)
+		if err != nil {
+			continue
+		}
+		profileNames := readChromiumProfileNames(baseDir)
+
+		for _, item := range items {
+			if !item.IsDir() {
+				continue
+			}
+			bookmarksFile := filepath.Join(baseDir, item.Name(), "Bookmarks")
+			resolved, err := filepath.EvalSymlinks(bookmarksFile)
+			if err != nil || seen[resolved] {
+				continue
+			}
+			seen[resolved] = true
+
+			profileName, ok := profileNames[item.Name()]
+			if !ok {
+				profileName = item.Name()
+			}
+			name := fmt.Sprintf("%s (%s)", root.label, profileName)
+			if _, taken := files[name]; taken {
+				name = fmt.Sprintf("%s (%s, %s)", root.label, profileName, item.Name())
+			}
+			log.Println(name, bookmarksFile)
+			files[name] = bookmarksFile
+		}
+	}
+
+	return files
+}
+
+// readChromiumProfileNames returns names the user gave to the profiles by
+// profile directory name
+func readChromiumProfileNames(baseDir string) map[string]string {
+	ret := make(map[string]string)
+
+	data, err := os.ReadFile(filepath.Join(baseDir, "Local State"))
+	if err != nil {
+		return ret
+	}
+	var state struct {
+		Profile struct {
+			InfoCache map[string]struct {
+				Name string `json:"name"`
+			} `json:"info_cache"`
+		} `json:"profile"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Println(err)
+		return ret
+	}
+
+	for dir, info := range state.Profile.InfoCache {
+		if info.Name != "" {
+			ret[dir] = info.Name
+		}
+	}
+	return ret
+}
@@ -0,0 +1,142 @@
+package implementation
+
+import (
+	"database/sql"
+	"errors"
+	"math/bits"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+Firefox keeps favicons in favicons.sqlite next to places.sqlite. Icons are
+linked to pages, except for the site-wide /favicon.ico ones which are marked
+as root icons and apply to every page of the site.
+*/
+const (
+	faviconsFileName = "favicons.sqlite"
+	// Size which looks best in the list, larger icons are scaled down
+	faviconPreferredWidth = 32
+)
+
+// mozillaFavicons keeps the favicons database open between icons, it is
+// opened again once Firefox writes to it
+type mozillaFavicons struct {
+	path    string
+	db      *sql.DB
+	done    func()
+	modTime time.Time
+	mx      sync.Mutex
+}
+
+func newMozillaFavicons(path string) *mozillaFavicons {
+	return &mozillaFavicons{path: path}
+}
+
+func (self *MozillaImplementation) LoadIcon(note *types.Note) ([]byte, error) {
+	return self.favicons.load(note.URI)
+}
+
+func (self *MozillaSessionImplementation) LoadIcon(note *types.Note) ([]byte, error) {
+	return self.favicons.load(note.URI)
+}
+
+func (self *mozillaFavicons) load(pageURL string) ([]byte, error) {
+	if pageURL == "" {
+		return nil, nil
+	}
+
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	db, err := self.open()
+	if err != nil || db == nil {
+		return nil, err
+	}
+
+	// page_url alone is not indexed, its hash is
+	var data []byte
+	err = db.QueryRow(`select i.data from moz_pages_w_icons p, moz_icons_to_pages ip, moz_icons i
+		where p.page_url_hash = ? and p.page_url = ? and ip.page_id = p.id
+		and i.id = ip.icon_id and i.data is not null
+		order by abs(i.width - ?) limit 1`,
+		int64(mozillaURLHash(pageURL)), pageURL, faviconPreferredWidth).Scan(&data)
+	if err == nil {
+		return data, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	parsed, err := url.Parse(pageURL)
+	if err != nil || parsed.Host == "" {
+		return nil, nil
+	}
+	err = db.QueryRow(`select data from moz_icons
+		where root = 1 and icon_url = ? and data is not null
+		order by abs(width - ?) limit 1`,
+		parsed.Scheme+"://"+parsed.Host+"/favicon.ico", faviconPreferredWidth).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// open returns the database, nil if there is none. Must be called with the
+// mutex held.
+func (self *mozillaFavicons) open() (*sql.DB, error) {
+	info, err := os.Stat(self.path)
+	if err != nil {
+		self.close()
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if self.db != nil && info.ModTime().Equal(self.modTime) {
+		return self.db, nil
+	}
+
+	self.close()
+	db, done, err := openMozillaDB(self.path)
+	if err != nil {
+		return nil, err
+	}
+	self.db, self.done, self.modTime = db, done, info.ModTime()
+	return db, nil
+}
+
+func (self *mozillaFavicons) close() {
+	if self.db != nil {
+		self.done()
+		self.db, self.done = nil, nil
+	}
+}
+
+// mozillaURLHash is hash(url) of Places: the hash of the scheme in the upper
+// 16 bits of 48 and the hash of the whole URL in the lower 32, see HashURL in
+// toolkit/components/places/Helpers.cpp
+func mozillaURLHash(spec string) uint64 {
+	const maxSpecLength = 1500
+	ret := uint64(mozillaHashString(spec[:min(len(spec), maxSpecLength)]))
+	// Schemes are looked for in the first 50 characters
+	if scheme, _, found := strings.Cut(spec[:min(len(spec), 50)], ":"); found {
+		ret += uint64(mozillaHashString(scheme)&0xffff) << 32
+	}
+	return ret
+}
+
+// mozillaHashString is HashString of mfbt/HashFunctions.h
+func mozillaHashString(in string) uint32 {
+	const goldenRatio = 0x9e3779b9
+	var hash uint32
+	for i := 0; i < len(in); i++ {
+		hash = goldenRatio * (bits.RotateLeft32(hash, 5) ^ uint32(in[i]))
+	}
+	return hash
+}
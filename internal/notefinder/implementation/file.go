@@ -2,32 +2,50 @@ package implementation
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
-	"syscall"
+	"sync"
 
 	"github.com/gabriel-vasile/mimetype"
 
 	"notefinder/internal/notefinder/types"
 )
 
+const (
+	tempFilePattern = ".notefinder-*.tmp"
+
+	// Path of the file relative to the notebook directory
+	pathProperty = "Path"
+)
+
+var (
+	ErrConflict = errors.New("note was changed since it was loaded")
+
+	tempFileRe = regexp.MustCompile(`^\.notefinder-.*\.tmp$`)
+	vimSwapRe  = regexp.MustCompile(`(^|/)\..*\.sw[pon]$|\.sw[pon]$`)
+)
+
 type FileImplementation struct {
 	path         string
 	useExtension bool
+	// Relative paths of the loaded files, to tell what was deleted
+	uuids map[string]uint64
+	mx    sync.Mutex
 }
 
 func NewFileImplementation(config map[string]string) *FileImplementation {
 	return &FileImplementation{path: config["path"],
-		useExtension: false}
+		useExtension: false, uuids: make(map[string]uint64)}
 }
 
 func (self *FileImplementation) CanWrite() (bool, error) {
-	tmpFile := "tmpfile"
-	file, err := os.CreateTemp(self.path, tmpFile)
+	file, err := os.CreateTemp(self.path, tempFilePattern)
 	if err != nil {
 		return false, err
 	}
@@ -39,7 +57,8 @@ func (self *FileImplementation) CanWrite() (bool, error) {
 }
 
 func (self *FileImplementation) SupportedProperties() map[string]types.Writable {
-	return map[string]types.Writable{"Title": true, "URI": false, "Body": true}
+	return map[string]types.Writable{"Title": true, "URI": false, "Body": true,
+		"Archived": true}
 }
 
 func processDir(path string, dst map[uint64]*types.Note, paths []string) error {
@@ -51,73 +70,90 @@ func processDir(path string, dst map[uint64]*types.Note, paths []string) error {
 
 	for _, f := range files {
 		if f.IsDir() {
-			paths = append(paths, string(f.Name()))
-			err = processDir(filepath.Join(path, string(f.Name())), dst, paths)
+			subPaths := append(slices.Clone(paths), string(f.Name()))
+			err = processDir(filepath.Join(path, string(f.Name())), dst, subPaths)
 			if err != nil {
 				log.Println(err)
 			}
 			continue
 		}
-		fileName := string(f.Name())
-
-		// vim temporary files
-		if regexp.MustCompile(`(^|/)\..*\.sw[pon]$|\.sw[pon]$`).MatchString(fileName) {
-			continue
-		}
-
-		filePath := filepath.Join(path, fileName)
-		var stat syscall.Stat_t
-		if err := syscall.Stat(filePath, &stat); err != nil {
-			log.Println(err)
-			continue
-		}
 
-		content, err := os.ReadFile(filePath)
+		note, err := processFile(path, string(f.Name()), paths)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
-
-		var body string
-		if !bytes.ContainsRune(content, 0) {
-			body = string(content)
-		}
-		var setArchived bool
-		var name string
-		if len(fileName) >= 2 && strings.HasPrefix(fileName, ".") {
-			setArchived = true
-			name = fileName[1:]
-		} else {
-			name = fileName
+		if note != nil {
+			dst[note.UUID] = note
 		}
+	}
 
-		note := types.NewNote(stat.Ino, name)
-		note.Set("Body", body, true)
+	return nil
+}
 
-		if setArchived {
-			note.SetFlag(types.FlagArchived)
-		}
+// processFile loads a single file, returns nil note for files to be ignored
+func processFile(path string, fileName string, paths []string) (*types.Note, error) {
+	// vim and our own temporary files
+	if vimSwapRe.MatchString(fileName) || tempFileRe.MatchString(fileName) {
+		return nil, nil
+	}
+
+	filePath := filepath.Join(path, fileName)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, nil
+	}
 
-		if body != "" {
-			note.Type = types.NoteTypeRegular
-		} else {
-			note.Type = types.NoteTypeFile
-			note.URI = "file://" + filePath
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
 
-			mime, err := mimetype.DetectFile(filePath)
-			if err == nil {
-				note.MimeType = mime.String()
-			}
-		}
-		if len(paths) > 0 {
-			note.Tags = make([]string, len(paths))
-			copy(note.Tags, paths)
-		}
+	var body string
+	if !bytes.ContainsRune(content, 0) {
+		body = string(content)
+	}
+	var setArchived bool
+	var name string
+	if len(fileName) >= 2 && strings.HasPrefix(fileName, ".") {
+		setArchived = true
+		name = fileName[1:]
+	} else {
+		name = fileName
+	}
 
-		dst[stat.Ino] = note
+	// Saving replaces the file, so the inode does not last, the path does
+	relPath := filepath.Join(append(slices.Clone(paths), fileName)...)
+	note := types.NewNote(stringUUID(relPath), name)
+	note.Set("Body", body, true)
+	note.CreatedAt = birthTime(filePath, info)
+	note.ModifiedAt = info.ModTime()
+	note.AdditionalProperties = map[string]string{pathProperty: relPath}
+
+	if setArchived {
+		note.SetFlag(types.FlagArchived)
 	}
 
-	return nil
+	if body != "" {
+		note.Type = types.NoteTypeRegular
+	} else {
+		note.Type = types.NoteTypeFile
+		note.URI = "file://" + filePath
+
+		mime, err := mimetype.DetectFile(filePath)
+		if err == nil {
+			note.MimeType = mime.String()
+		}
+	}
+	if len(paths) > 0 {
+		note.Tags = make([]string, len(paths))
+		copy(note.Tags, paths)
+	}
+
+	return note, nil
 }
 
 func (self *FileImplementation) LoadData() (map[uint64]*types.Note, error) {
@@ -128,6 +164,13 @@ func (self *FileImplementation) LoadData() (map[uint64]*types.Note, error) {
 		return nil, err
 	}
 
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	self.uuids = make(map[string]uint64, len(data))
+	for uuid, note := range data {
+		self.uuids[note.AdditionalProperties[pathProperty]] = uuid
+	}
+
 	return data, nil
 }
 
@@ -137,7 +180,7 @@ func normalizeTitle(in string) string {
 
 func (self *FileImplementation) PutData(note *types.Note) error {
 	note.Title = normalizeTitle(note.Title)
-	path := filepath.Join(self.path, note.Title)
+	path := self.notePath(note)
 	_, err := os.Stat(path)
 	fmt.Println(note)
 	if err == nil {
@@ -146,6 +189,10 @@ func (self *FileImplementation) PutData(note *types.Note) error {
 		return err
 	}
 
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Println(err)
+		return err
+	}
 	if err = os.WriteFile(path, []byte(note.Body), 0644); err != nil {
 		log.Println(err)
 		return err
@@ -154,12 +201,120 @@ func (self *FileImplementation) PutData(note *types.Note) error {
 	return nil
 }
 
+// UpdateData atomically rewrites the file contents if the body changed and
+// then renames it if the title or archived flag changed. It refuses to touch
+// files modified by someone else since the note was loaded.
 func (self *FileImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	oldPath := self.notePath(oldNote)
+	info, err := os.Stat(oldPath)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if !oldNote.ModifiedAt.IsZero() && !info.ModTime().Equal(oldNote.ModifiedAt) {
+		return fmt.Errorf("%w: \"%s\" was modified on disk", ErrConflict, oldNote.Title)
+	}
+
+	newNote.Title = normalizeTitle(newNote.Title)
+	if newNote.Title == "" {
+		return errors.New("title cannot be empty")
+	}
+
+	newPath := self.notePath(newNote)
+	if newPath != oldPath {
+		if _, err := os.Stat(newPath); err == nil {
+			err = fmt.Errorf("\"%s\" already exists, cannot rename", newNote.Title)
+			log.Println(err)
+			return err
+		}
+	}
+
+	// A failed write leaves the file as it was, a failed rename leaves it
+	// where the note says it is
+	if newNote.Type == types.NoteTypeRegular && newNote.Body != oldNote.Body {
+		if err := writeFileAtomic(oldPath, []byte(newNote.Body), info.Mode().Perm()); err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+	if newPath != oldPath {
+		if err := os.Rename(oldPath, newPath); err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+
+	if info, err = os.Stat(newPath); err == nil {
+		newNote.CreatedAt = birthTime(newPath, info)
+		newNote.ModifiedAt = info.ModTime()
+	}
+	if relPath, err := filepath.Rel(self.path, newPath); err == nil {
+		if newNote.AdditionalProperties == nil {
+			newNote.AdditionalProperties = make(map[string]string)
+		}
+		newNote.AdditionalProperties[pathProperty] = relPath
+		newNote.UUID = stringUUID(relPath)
+	}
+
 	return nil
 }
 
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), tempFilePattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(file.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// copySaved updates note with what was read back after saving it. Only the
+// fields the notebook stores, and of the flags those in flags, are copied,
+// the rest, e.g. the annotation, may be kept in the overlay.
+func copySaved(note *types.Note, saved *types.Note, flags uint32) {
+	note.UUID = saved.UUID
+	note.Title = saved.Title
+	note.Body = saved.Body
+	note.Tags = saved.Tags
+	note.URI = saved.URI
+	note.Type = saved.Type
+	note.Markup = saved.Markup
+	note.CreatedAt = saved.CreatedAt
+	note.ModifiedAt = saved.ModifiedAt
+	note.AdditionalProperties = saved.AdditionalProperties
+	note.UnsetFlag(flags)
+	note.SetFlag(saved.Flags() & flags)
+}
+
 func (self *FileImplementation) DeleteData(note *types.Note) error {
-	return os.Remove(filepath.Join(self.path, filenameString(note)))
+	return os.Remove(self.notePath(note))
+}
+
+// notePath returns where the note is (or should be) stored: next to the file
+// it was loaded from or, for new notes, in the folder named by the first tag,
+// which may not exist yet
+func (self *FileImplementation) notePath(note *types.Note) string {
+	var dir string
+	if relPath, ok := note.AdditionalProperties[pathProperty]; ok {
+		dir = filepath.Dir(relPath)
+	} else if len(note.Tags) > 0 && filepath.IsLocal(normalizeTitle(note.Tags[0])) {
+		dir = normalizeTitle(note.Tags[0])
+	}
+	return filepath.Join(self.path, dir, filenameString(note))
 }
 
 func filenameString(note *types.Note) string {
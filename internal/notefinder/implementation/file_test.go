@@ -0,0 +1,82 @@
+package implementation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"notefinder/internal/notefinder/types"
+)
+
+func TestFileUpdateKeepsUUID(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "work"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "work", "plan"), []byte("draft"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	impl := NewFileImplementation(map[string]string{"path": dir})
+
+	data, err := impl.LoadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 {
+		t.Fatalf("loaded %v", data)
+	}
+	var oldNote *types.Note
+	for _, note := range data {
+		oldNote = note
+	}
+
+	edited := oldNote.Clone()
+	edited.Set("Body", "final", true)
+	if err := impl.UpdateData(oldNote, edited); err != nil {
+		t.Fatal(err)
+	}
+	if edited.UUID != oldNote.UUID {
+		t.Errorf("UUID changed from %d to %d on edit", oldNote.UUID, edited.UUID)
+	}
+	data, err = impl.LoadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note, ok := data[oldNote.UUID]; !ok || note.Body != "final" {
+		t.Errorf("reloaded %v, want the edited note", data)
+	}
+
+	renamed := edited.Clone()
+	renamed.Title = "schedule"
+	if err := impl.UpdateData(edited, renamed); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "work", "schedule"))
+	if err != nil || string(content) != "final" {
+		t.Errorf("renamed file has %q, %v", content, err)
+	}
+	data, err = impl.LoadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := data[renamed.UUID]; !ok {
+		t.Errorf("renamed note %d not found after reload: %v", renamed.UUID, data)
+	}
+}
+
+func TestFilePutCreatesTagFolder(t *testing.T) {
+	dir := t.TempDir()
+	impl := NewFileImplementation(map[string]string{"path": dir})
+
+	note := types.NewNote(0, "groceries")
+	note.Set("Body", "milk", true)
+	note.Tags = []string{"home"}
+	if err := impl.PutData(note); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "home", "groceries"))
+	if err != nil || string(content) != "milk" {
+		t.Errorf("new file has %q, %v", content, err)
+	}
+}
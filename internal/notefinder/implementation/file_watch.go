@@ -0,0 +1,232 @@
+package implementation
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"notefinder/internal/notefinder/types"
+)
+
+const (
+	// Editors tend to produce bursts of events on save
+	watchDebounce = 200 * time.Millisecond
+)
+
+func (self *FileImplementation) Watch(changes chan<- *types.ChangeSet, stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watchTree(watcher, self.path); err != nil {
+		return err
+	}
+
+	pending := make(map[string]bool)
+	var reload bool
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Println(err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			relPath, err := filepath.Rel(self.path, event.Name)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				// fsnotify is not recursive, so pick up new directories
+				info, err := os.Stat(event.Name)
+				if err == nil && info.IsDir() {
+					if err := watchTree(watcher, event.Name); err != nil {
+						log.Println(err)
+					}
+					reload = true
+				}
+			}
+			pending[relPath] = true
+			timer.Reset(watchDebounce)
+		case <-timer.C:
+			changeSet := self.collectChanges(pending, reload)
+			pending = make(map[string]bool)
+			reload = false
+
+			select {
+			case changes <- changeSet:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
+
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// collectChanges reloads only the files touched since the last call
+func (self *FileImplementation) collectChanges(relPaths map[string]bool, reload bool) *types.ChangeSet {
+	changeSet := types.NewChangeSet()
+	if reload {
+		changeSet.Reload = true
+		return changeSet
+	}
+
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	for relPath := range relPaths {
+		if self.reloadFile(relPath, changeSet) {
+			continue
+		}
+		// A whole directory might have gone
+		for path := range self.uuids {
+			if strings.HasPrefix(path, relPath+string(filepath.Separator)) {
+				changeSet.Reload = true
+				return changeSet
+			}
+		}
+	}
+
+	return changeSet
+}
+
+// LoadChanges rereads files modified since the cursor (the latest
+// modification time seen, in nanoseconds) and files not seen before
+func (self *FileImplementation) LoadChanges(cursor string) (*types.ChangeSet, string, error) {
+	since, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil {
+		data, err := self.LoadData()
+		if err != nil {
+			return nil, cursor, err
+		}
+
+		var latest time.Time
+		for _, note := range data {
+			if note.ModifiedAt.After(latest) {
+				latest = note.ModifiedAt
+			}
+		}
+		changeSet := types.NewChangeSet()
+		changeSet.Added = data
+		changeSet.Snapshot = true
+		return changeSet, strconv.FormatInt(latest.UnixNano(), 10), nil
+	}
+
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	changeSet := types.NewChangeSet()
+	sinceTime := time.Unix(0, since)
+	latest := sinceTime
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(self.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == self.path {
+				return err
+			}
+			log.Println(err)
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(self.path, path)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		seen[relPath] = true
+
+		// Timestamps are not unique, so the latest files are read again
+		_, known := self.uuids[relPath]
+		if known && info.ModTime().Before(sinceTime) {
+			return nil
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		self.reloadFile(relPath, changeSet)
+		return nil
+	})
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	for relPath, uuid := range self.uuids {
+		if !seen[relPath] {
+			changeSet.Deleted = append(changeSet.Deleted, uuid)
+			delete(self.uuids, relPath)
+		}
+	}
+
+	return changeSet, strconv.FormatInt(latest.UnixNano(), 10), nil
+}
+
+// reloadFile records what happened to the file in changeSet, it returns false
+// if there is no such file and there was none before. Must be called with
+// the mutex held.
+func (self *FileImplementation) reloadFile(relPath string, changeSet *types.ChangeSet) bool {
+	dir, fileName := filepath.Split(relPath)
+	var paths []string
+	if dir != "" {
+		paths = strings.Split(filepath.Clean(dir), string(filepath.Separator))
+	}
+
+	oldUUID, known := self.uuids[relPath]
+	note, err := processFile(filepath.Join(self.path, dir), fileName, paths)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+
+	if note == nil {
+		if known {
+			changeSet.Deleted = append(changeSet.Deleted, oldUUID)
+			delete(self.uuids, relPath)
+		}
+		return known
+	}
+
+	if known && oldUUID == note.UUID {
+		changeSet.Changed[note.UUID] = note
+	} else {
+		if known {
+			changeSet.Deleted = append(changeSet.Deleted, oldUUID)
+		}
+		changeSet.Added[note.UUID] = note
+	}
+	self.uuids[relPath] = note.UUID
+	return true
+}
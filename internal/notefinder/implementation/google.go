@@ -1,17 +1,58 @@
 package implementation
 
 import (
-	_ "encoding/json"
+	"archive/zip"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"notefinder/internal/notefinder/types"
+	"notefinder/internal/notefinder/util"
 )
 
+/*
+Reads a Google Keep export made with Google Takeout, either unpacked or the
+zip file as downloaded. Every note is a JSON file next to its attachments.
+*/
 type GoogleImplementation struct {
+	path string
+}
+
+type keepNote struct {
+	Title       string `json:"title"`
+	TextContent string `json:"textContent"`
+	ListContent []struct {
+		Text      string `json:"text"`
+		IsChecked bool   `json:"isChecked"`
+	} `json:"listContent"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Annotations []struct {
+		URL   string `json:"url"`
+		Title string `json:"title"`
+	} `json:"annotations"`
+	Attachments []struct {
+		FilePath string `json:"filePath"`
+		Mimetype string `json:"mimetype"`
+	} `json:"attachments"`
+	IsPinned                bool  `json:"isPinned"`
+	IsArchived              bool  `json:"isArchived"`
+	IsTrashed               bool  `json:"isTrashed"`
+	CreatedTimestampUsec    int64 `json:"createdTimestampUsec"`
+	UserEditedTimestampUsec int64 `json:"userEditedTimestampUsec"`
 }
 
 func NewGoogleImplementation(config map[string]string) *GoogleImplementation {
-	return &GoogleImplementation{}
+	return &GoogleImplementation{path: config["path"]}
 }
 
 func (self *GoogleImplementation) CanWrite() (bool, error) {
@@ -23,7 +64,131 @@ func (self *GoogleImplementation) SupportedProperties() map[string]types.Writabl
 }
 
 func (self *GoogleImplementation) LoadData() (map[uint64]*types.Note, error) {
-	return make(map[uint64]*types.Note, 0), nil
+	if self.path == "" {
+		return nil, errors.New("path to Google Takeout export is not set")
+	}
+	info, err := os.Stat(self.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fsys fs.FS
+	var attachmentDir string
+	if info.IsDir() {
+		fsys = os.DirFS(self.path)
+		attachmentDir = self.path
+	} else {
+		reader, err := zip.OpenReader(self.path)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		fsys = reader
+	}
+
+	data := make(map[uint64]*types.Note)
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(name) != ".json" {
+			return nil
+		}
+
+		// A broken or unrelated file should not hide all the other notes
+		note, err := readKeepNote(fsys, name, attachmentDir)
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			return nil
+		}
+		if note != nil {
+			data[note.UUID] = note
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// readKeepNote returns nil for trashed notes and JSON files which are not
+// notes, e.g. Labels.json
+func readKeepNote(fsys fs.FS, name string, attachmentDir string) (*types.Note, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var item keepNote
+	if err := json.Unmarshal(content, &item); err != nil {
+		return nil, err
+	}
+	if item.IsTrashed || item.CreatedTimestampUsec == 0 {
+		return nil, nil
+	}
+
+	// Takeout names files after the note, which is the closest thing to an id
+	note := types.NewNote(stringUUID(path.Base(name)), item.Title)
+	note.SetFlag(types.FlagReadOnly)
+	if item.ListContent != nil {
+		note.Type = types.NoteTypeTodoList
+		note.Markup = types.Markdown
+		lines := make([]string, 0, len(item.ListContent))
+		for _, entry := range item.ListContent {
+			mark := " "
+			if entry.IsChecked {
+				mark = "x"
+			}
+			lines = append(lines, fmt.Sprintf("- [%s] %s", mark, entry.Text))
+		}
+		note.Body = strings.Join(lines, "\n")
+	} else {
+		note.Body = item.TextContent
+	}
+	if note.Title == "" {
+		note.Title = util.ShortText(note.Body, 48)
+	}
+
+	for _, label := range item.Labels {
+		note.Tags = append(note.Tags, label.Name)
+	}
+	if item.IsPinned {
+		note.SetFlag(types.FlagStarred)
+	}
+	if item.IsArchived {
+		note.SetFlag(types.FlagArchived)
+	}
+	note.CreatedAt = time.UnixMicro(item.CreatedTimestampUsec)
+	note.ModifiedAt = note.CreatedAt
+	if item.UserEditedTimestampUsec != 0 {
+		note.ModifiedAt = time.UnixMicro(item.UserEditedTimestampUsec)
+	}
+
+	if len(item.Annotations) > 0 {
+		note.URI = item.Annotations[0].URL
+	}
+	if len(item.Attachments) > 0 {
+		attachments := make([]string, 0, len(item.Attachments))
+		for _, attachment := range item.Attachments {
+			attachmentPath := path.Join(path.Dir(name), attachment.FilePath)
+			if attachmentDir != "" {
+				attachmentPath = filepath.Join(attachmentDir, filepath.FromSlash(attachmentPath))
+			}
+			attachments = append(attachments, attachmentPath)
+		}
+		note.AdditionalProperties = map[string]string{
+			"Attachments": strings.Join(attachments, "\n"),
+		}
+	}
+
+	return note, nil
 }
 
 func (self *GoogleImplementation) PutData(note *types.Note) error {
@@ -0,0 +1,700 @@
+package implementation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+VTODO and VJOURNAL components of iCalendar (RFC 5545) files, either a single
+.ics file or a directory of them as kept by vdirsyncer. Properties not
+mapped onto notes are written back untouched.
+*/
+const (
+	icalExt          = ".ics"
+	icalDateTime     = "20060102T150405Z"
+	icalLocalTime    = "20060102T150405"
+	icalDate         = "20060102"
+	icalLineLimit    = 75
+	icalDueProperty  = "Due"
+	icalRemindersKey = "Reminders"
+	icalProdID       = "-//Notefinder//Notefinder//EN"
+)
+
+var icalDurationRe = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+type ICalImplementation struct {
+	path string
+	mx   sync.Mutex
+}
+
+type icalProperty struct {
+	name string
+	// Parameters as written, including the leading semicolon
+	params string
+	value  string
+}
+
+type icalComponent struct {
+	name       string
+	properties []*icalProperty
+	children   []*icalComponent
+}
+
+func NewICalImplementation(config map[string]string) *ICalImplementation {
+	return &ICalImplementation{path: config["path"]}
+}
+
+func (self *ICalImplementation) CanWrite() (bool, error) {
+	file, err := os.CreateTemp(self.dir(), tempFilePattern)
+	if err != nil {
+		return false, err
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	return true, nil
+}
+
+func (self *ICalImplementation) SupportedProperties() map[string]types.Writable {
+	return map[string]types.Writable{"Title": true, "Body": true, "Tags": true, "URI": true,
+		types.PropertyCompleted: true, types.PropertyPriority: true}
+}
+
+// dir is where new items go
+func (self *ICalImplementation) dir() string {
+	if self.isFile() {
+		return filepath.Dir(self.path)
+	}
+	return self.path
+}
+
+func (self *ICalImplementation) isFile() bool {
+	info, err := os.Stat(self.path)
+	if err != nil {
+		return filepath.Ext(self.path) == icalExt
+	}
+	return !info.IsDir()
+}
+
+func (self *ICalImplementation) LoadData() (map[uint64]*types.Note, error) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	data := make(map[uint64]*types.Note)
+	for _, path := range self.files() {
+		calendar, err := readICalFile(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, component := range calendar.children {
+			if note := icalNote(component); note != nil {
+				note.AdditionalProperties[pathProperty] = path
+				data[note.UUID] = note
+			}
+		}
+	}
+	return data, nil
+}
+
+func (self *ICalImplementation) files() []string {
+	if self.isFile() {
+		return []string{self.path}
+	}
+
+	files := make([]string, 0)
+	err := filepath.WalkDir(self.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == self.path {
+				return err
+			}
+			log.Println(err)
+			return nil
+		}
+		if !d.IsDir() && filepath.Ext(path) == icalExt {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	return files
+}
+
+func (self *ICalImplementation) PutData(note *types.Note) error {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	name := "VJOURNAL"
+	if note.Type == types.NoteTypeTodoList {
+		name = "VTODO"
+	}
+	uid, err := newICalUID()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = now
+	}
+	component := &icalComponent{name: name}
+	component.set("UID", uid)
+	component.set("DTSTAMP", now.Format(icalDateTime))
+	component.set("CREATED", note.CreatedAt.UTC().Format(icalDateTime))
+	if err := updateICalComponent(component, &types.Note{}, note, now); err != nil {
+		return err
+	}
+
+	var path string
+	var calendar *icalComponent
+	if self.isFile() {
+		path = self.path
+		if calendar, err = readICalFile(path); os.IsNotExist(err) {
+			calendar = newICalCalendar()
+		} else if err != nil {
+			return err
+		}
+	} else {
+		path = filepath.Join(self.path, uid+icalExt)
+		calendar = newICalCalendar()
+	}
+	calendar.children = append(calendar.children, component)
+
+	if err := writeICalFile(path, calendar); err != nil {
+		return err
+	}
+	copySaved(note, icalNote(component), types.FlagNotify)
+	note.AdditionalProperties[pathProperty] = path
+	return nil
+}
+
+// UpdateData rewrites the component in place. It refuses to touch items
+// modified by someone else since the note was loaded.
+func (self *ICalImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	path := oldNote.AdditionalProperties[pathProperty]
+	calendar, component, err := self.find(oldNote)
+	if err != nil {
+		return err
+	}
+	if modifiedAt := icalNote(component).ModifiedAt; !oldNote.ModifiedAt.IsZero() &&
+		!modifiedAt.Equal(oldNote.ModifiedAt) {
+		return fmt.Errorf("%w: \"%s\" was modified on disk", ErrConflict, oldNote.Title)
+	}
+
+	if err := updateICalComponent(component, oldNote, newNote, time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := writeICalFile(path, calendar); err != nil {
+		return err
+	}
+
+	copySaved(newNote, icalNote(component), types.FlagNotify)
+	newNote.AdditionalProperties[pathProperty] = path
+	return nil
+}
+
+func (self *ICalImplementation) DeleteData(note *types.Note) error {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	path := note.AdditionalProperties[pathProperty]
+	calendar, component, err := self.find(note)
+	if err != nil {
+		return err
+	}
+	calendar.children = slices.DeleteFunc(calendar.children, func(child *icalComponent) bool {
+		return child == component
+	})
+
+	// One item per file is the norm in directories
+	if !self.isFile() && !slices.ContainsFunc(calendar.children, func(child *icalComponent) bool {
+		return child.name != "VTIMEZONE"
+	}) {
+		return os.Remove(path)
+	}
+	return writeICalFile(path, calendar)
+}
+
+func (self *ICalImplementation) find(note *types.Note) (*icalComponent, *icalComponent, error) {
+	path, ok := note.AdditionalProperties[pathProperty]
+	if !ok {
+		return nil, nil, errors.New("note does not belong to the calendar")
+	}
+	calendar, err := readICalFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, component := range calendar.children {
+		if other := icalNote(component); other != nil && other.UUID == note.UUID {
+			return calendar, component, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: \"%s\" is not in %s anymore", ErrConflict, note.Title, path)
+}
+
+// icalNote returns nil for components other than VTODO and VJOURNAL
+func icalNote(component *icalComponent) *types.Note {
+	if component.name != "VTODO" && component.name != "VJOURNAL" {
+		return nil
+	}
+
+	uid := component.value("UID")
+	note := types.NewNote(stringUUID(uid, component.value("RECURRENCE-ID")),
+		icalUnescape(component.value("SUMMARY")))
+	note.Set("Body", icalUnescape(component.value("DESCRIPTION")), true)
+	note.URI = component.value("URL")
+	note.AdditionalProperties = make(map[string]string)
+	for _, property := range component.properties {
+		if property.name == "CATEGORIES" {
+			for _, tag := range icalSplitList(property.value) {
+				if tag != "" && !slices.Contains(note.Tags, tag) {
+					note.Tags = append(note.Tags, tag)
+				}
+			}
+		}
+	}
+
+	note.CreatedAt = component.time("CREATED")
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = component.time("DTSTART")
+	}
+	note.ModifiedAt = component.time("LAST-MODIFIED")
+	if note.ModifiedAt.IsZero() {
+		note.ModifiedAt = component.time("DTSTAMP")
+	}
+
+	if component.name == "VJOURNAL" {
+		note.Type = types.NoteTypeRegular
+		return note
+	}
+
+	note.Type = types.NoteTypeTodoList
+	if priority := component.value("PRIORITY"); priority != "" && priority != "0" {
+		note.AdditionalProperties[types.PropertyPriority] = priority
+	}
+	completed := component.value("STATUS") == "COMPLETED" || component.get("COMPLETED") != nil
+	if completed {
+		note.AdditionalProperties[types.PropertyCompleted] = ""
+		if at := component.time("COMPLETED"); !at.IsZero() {
+			note.AdditionalProperties[types.PropertyCompleted] = at.Format(time.DateOnly)
+		}
+	}
+
+	due := component.time("DUE")
+	if !due.IsZero() {
+		note.AdditionalProperties[icalDueProperty] = formatICalTime(due)
+	}
+	reminders := make([]string, 0)
+	for _, alarm := range component.children {
+		if alarm.name != "VALARM" {
+			continue
+		}
+		if at := alarm.trigger(component); !at.IsZero() {
+			reminders = append(reminders, formatICalTime(at))
+		}
+	}
+	if len(reminders) > 0 {
+		note.AdditionalProperties[icalRemindersKey] = strings.Join(reminders, ", ")
+	}
+	if !completed && (!due.IsZero() || len(reminders) > 0) {
+		note.SetFlag(types.FlagNotify)
+	}
+
+	return note
+}
+
+func formatICalTime(at time.Time) string {
+	return at.Local().Format("2006-01-02 15:04")
+}
+
+// updateICalComponent writes fields of newNote changed since oldNote into
+// the component
+func updateICalComponent(component *icalComponent, oldNote *types.Note, newNote *types.Note,
+	now time.Time) error {
+	if newNote.Title != oldNote.Title {
+		component.set("SUMMARY", icalEscape(newNote.Title))
+	}
+	if newNote.Body != oldNote.Body {
+		component.setOrDelete("DESCRIPTION", icalEscape(newNote.Body))
+	}
+	if newNote.URI != oldNote.URI {
+		component.setOrDelete("URL", newNote.URI)
+	}
+	if !slices.Equal(newNote.Tags, oldNote.Tags) {
+		tags := make([]string, 0, len(newNote.Tags))
+		for _, tag := range newNote.Tags {
+			tags = append(tags, icalEscape(tag))
+		}
+		component.delete("CATEGORIES")
+		component.setOrDelete("CATEGORIES", strings.Join(tags, ","))
+	}
+
+	if component.name == "VTODO" {
+		oldPriority := oldNote.AdditionalProperties[types.PropertyPriority]
+		if priority := newNote.AdditionalProperties[types.PropertyPriority]; priority != oldPriority {
+			value, err := icalPriority(priority)
+			if err != nil {
+				return err
+			}
+			component.setOrDelete("PRIORITY", value)
+		}
+
+		_, wasCompleted := oldNote.AdditionalProperties[types.PropertyCompleted]
+		_, completed := newNote.AdditionalProperties[types.PropertyCompleted]
+		if completed && !wasCompleted {
+			component.set("STATUS", "COMPLETED")
+			component.set("COMPLETED", now.Format(icalDateTime))
+			component.set("PERCENT-COMPLETE", "100")
+		} else if !completed && wasCompleted {
+			component.set("STATUS", "NEEDS-ACTION")
+			component.delete("COMPLETED")
+			component.delete("PERCENT-COMPLETE")
+		}
+	}
+
+	component.set("LAST-MODIFIED", now.Format(icalDateTime))
+	component.set("DTSTAMP", now.Format(icalDateTime))
+	if sequence := component.get("SEQUENCE"); sequence != nil {
+		value, _ := strconv.Atoi(sequence.value)
+		sequence.value = strconv.Itoa(value + 1)
+	}
+	return nil
+}
+
+// icalPriority converts a priority given as a number from 1 (highest) to 9,
+// a letter or a word into the PRIORITY value
+func icalPriority(priority string) (string, error) {
+	if priority == "" {
+		return "", nil
+	}
+	// The letters are those of RFC 5545 and todo.txt, A is the highest
+	switch strings.ToLower(priority) {
+	case "a", "high":
+		return "1", nil
+	case "b", "medium":
+		return "5", nil
+	case "c", "low":
+		return "9", nil
+	}
+	if value, err := strconv.Atoi(priority); err == nil && value >= 0 && value <= 9 {
+		return strconv.Itoa(value), nil
+	}
+	return "", fmt.Errorf("invalid priority \"%s\", use 1 to 9 or A to C", priority)
+}
+
+func newICalCalendar() *icalComponent {
+	calendar := &icalComponent{name: "VCALENDAR"}
+	calendar.set("VERSION", "2.0")
+	calendar.set("PRODID", icalProdID)
+	return calendar
+}
+
+func newICalUID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf) + "@notefinder", nil
+}
+
+// readICalFile returns the VCALENDAR in the file
+func readICalFile(path string) (*icalComponent, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	calendar, err := parseICal(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return calendar, nil
+}
+
+func writeICalFile(path string, calendar *icalComponent) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	var out strings.Builder
+	calendar.render(&out)
+	return writeFileAtomic(path, []byte(out.String()), perm)
+}
+
+func parseICal(content string) (*icalComponent, error) {
+	// Long lines are folded by a line break followed by a space or a tab
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.ReplaceAll(content, "\n ", "")
+	content = strings.ReplaceAll(content, "\n\t", "")
+
+	var calendar *icalComponent
+	stack := make([]*icalComponent, 0)
+	for _, line := range strings.Split(content, "\n") {
+		if line == "" {
+			continue
+		}
+		property := parseICalProperty(line)
+		switch property.name {
+		case "BEGIN":
+			component := &icalComponent{name: strings.ToUpper(property.value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, component)
+			} else if calendar == nil {
+				calendar = component
+			}
+			stack = append(stack, component)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].name != strings.ToUpper(property.value) {
+				return nil, fmt.Errorf("unexpected END:%s", property.value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("property %s outside of a component", property.name)
+			}
+			component := stack[len(stack)-1]
+			component.properties = append(component.properties, property)
+		}
+	}
+
+	if calendar == nil || calendar.name != "VCALENDAR" {
+		return nil, errors.New("no VCALENDAR found")
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("missing END:%s", stack[len(stack)-1].name)
+	}
+	return calendar, nil
+}
+
+func parseICalProperty(line string) *icalProperty {
+	// The value starts after the first colon which is not in a quoted
+	// parameter value
+	quoted := false
+	colon := len(line)
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+
+	head := line[:colon]
+	property := &icalProperty{}
+	if colon < len(line) {
+		property.value = line[colon+1:]
+	}
+	if semicolon := strings.IndexByte(head, ';'); semicolon >= 0 {
+		property.name, property.params = head[:semicolon], head[semicolon:]
+	} else {
+		property.name = head
+	}
+	property.name = strings.ToUpper(property.name)
+	return property
+}
+
+func (self *icalComponent) render(out *strings.Builder) {
+	writeICalLine(out, "BEGIN:"+self.name)
+	for _, property := range self.properties {
+		writeICalLine(out, property.name+property.params+":"+property.value)
+	}
+	for _, child := range self.children {
+		child.render(out)
+	}
+	writeICalLine(out, "END:"+self.name)
+}
+
+// writeICalLine folds the line at 75 octets without splitting characters
+func writeICalLine(out *strings.Builder, line string) {
+	limit := icalLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xc0 == 0x80 {
+			cut--
+		}
+		out.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// The leading space counts
+		limit = icalLineLimit - 1
+	}
+	out.WriteString(line + "\r\n")
+}
+
+func (self *icalComponent) get(name string) *icalProperty {
+	for _, property := range self.properties {
+		if property.name == name {
+			return property
+		}
+	}
+	return nil
+}
+
+func (self *icalComponent) value(name string) string {
+	if property := self.get(name); property != nil {
+		return property.value
+	}
+	return ""
+}
+
+func (self *icalComponent) set(name string, value string) {
+	if property := self.get(name); property != nil {
+		property.value = value
+		return
+	}
+	self.properties = append(self.properties, &icalProperty{name: name, value: value})
+}
+
+func (self *icalComponent) setOrDelete(name string, value string) {
+	if value == "" {
+		self.delete(name)
+		return
+	}
+	self.set(name, value)
+}
+
+func (self *icalComponent) delete(name string) {
+	self.properties = slices.DeleteFunc(self.properties, func(property *icalProperty) bool {
+		return property.name == name
+	})
+}
+
+func (self *icalComponent) time(name string) time.Time {
+	property := self.get(name)
+	if property == nil {
+		return time.Time{}
+	}
+	return parseICalTime(property.value, icalParam(property.params, "TZID"))
+}
+
+// trigger returns when the alarm goes off, relative triggers are relative
+// to the start of the parent or its due time
+func (self *icalComponent) trigger(parent *icalComponent) time.Time {
+	property := self.get("TRIGGER")
+	if property == nil {
+		return time.Time{}
+	}
+	if strings.EqualFold(icalParam(property.params, "VALUE"), "DATE-TIME") {
+		return parseICalTime(property.value, "")
+	}
+
+	offset, ok := parseICalDuration(property.value)
+	if !ok {
+		return time.Time{}
+	}
+	base := parent.time("DTSTART")
+	if strings.EqualFold(icalParam(property.params, "RELATED"), "END") || base.IsZero() {
+		base = parent.time("DUE")
+	}
+	if base.IsZero() {
+		return time.Time{}
+	}
+	return base.Add(offset)
+}
+
+func icalParam(params string, name string) string {
+	for _, param := range strings.Split(params, ";") {
+		if key, value, ok := strings.Cut(param, "="); ok && strings.EqualFold(key, name) {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+func parseICalTime(value string, tzid string) time.Time {
+	location := time.Local
+	if tzid != "" {
+		if tz, err := time.LoadLocation(tzid); err == nil {
+			location = tz
+		}
+	}
+	if at, err := time.Parse(icalDateTime, value); err == nil {
+		return at
+	}
+	for _, layout := range []string{icalLocalTime, icalDate} {
+		if at, err := time.ParseInLocation(layout, value, location); err == nil {
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+func parseICalDuration(value string) (time.Duration, bool) {
+	match := icalDurationRe.FindStringSubmatch(value)
+	if match == nil {
+		return 0, false
+	}
+	var ret time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour,
+		time.Hour, time.Minute, time.Second} {
+		if n, err := strconv.Atoi(match[i+2]); err == nil {
+			ret += time.Duration(n) * unit
+		}
+	}
+	if match[1] == "-" {
+		ret = -ret
+	}
+	return ret, true
+}
+
+func icalUnescape(value string) string {
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			out.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			out.WriteByte('\n')
+		default:
+			out.WriteByte(value[i])
+		}
+	}
+	return out.String()
+}
+
+func icalEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(value)
+}
+
+// icalSplitList splits a comma separated value, honouring escaped commas
+func icalSplitList(value string) []string {
+	ret := make([]string, 0)
+	var item strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			item.WriteByte(value[i])
+			item.WriteByte(value[i+1])
+			i++
+		case value[i] == ',':
+			ret = append(ret, strings.TrimSpace(icalUnescape(item.String())))
+			item.Reset()
+		default:
+			item.WriteByte(value[i])
+		}
+	}
+	return append(ret, strings.TrimSpace(icalUnescape(item.String())))
+}
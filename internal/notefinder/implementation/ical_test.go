@@ -0,0 +1,31 @@
+package implementation
+
+import "testing"
+
+func TestICalPriority(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"", "", true},
+		{"1", "1", true},
+		{"9", "9", true},
+		{"0", "0", true},
+		{"A", "1", true},
+		{"b", "5", true},
+		{"C", "9", true},
+		{"High", "1", true},
+		{"low", "9", true},
+		{"10", "", false},
+		{"-1", "", false},
+		{"D", "", false},
+		{"urgent", "", false},
+	}
+
+	for _, test := range tests {
+		got, err := icalPriority(test.in)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("icalPriority(%q) = %q, %v, want %q", test.in, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package implementation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+The database.sqlite of Joplin desktop, opened immutable like the Firefox
+databases since Joplin keeps it open. Attachments live in the resources
+directory next to it as <id>.<extension>, notes refer to them as :/<id>.
+Times are in milliseconds since the epoch.
+*/
+const (
+	joplinDatabaseFile = "database.sqlite"
+	joplinResourcesDir = "resources"
+	joplinMarkupHTML   = 2
+
+	joplinNotebookProperty = "Notebook"
+)
+
+type joplinRoot struct {
+	// Relative to the home directory
+	path  string
+	label string
+}
+
+var joplinRoots = []joplinRoot{
+	{".config/joplin-desktop", "Joplin"},
+	{".var/app/net.cozic.joplin_desktop/config/joplin-desktop", "Joplin Flatpak"},
+	{".config/joplin", "Joplin CLI"},
+}
+
+var joplinResourceRe = regexp.MustCompile(`:/([0-9a-f]{32})`)
+
+type JoplinImplementation struct {
+	path string
+}
+
+type joplinFolder struct {
+	parent string
+	title  string
+}
+
+func NewJoplinImplementation(config map[string]string) *JoplinImplementation {
+	return &JoplinImplementation{path: config["path"]}
+}
+
+func (self *JoplinImplementation) CanWrite() (bool, error) {
+	return false, errors.New("Joplin notes are read only")
+}
+
+func (self *JoplinImplementation) SupportedProperties() map[string]types.Writable {
+	return map[string]types.Writable{"Title": false, "URI": false, "Body": false}
+}
+
+func (self *JoplinImplementation) LoadData() (map[uint64]*types.Note, error) {
+	db, done, err := openMozillaDB(self.path)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	return self.loadNotes(db, "")
+}
+
+// LoadChanges loads notes updated since the cursor, which holds the latest
+// update time and the number of notes seen. Deleted notes, renamed folders
+// and retagging make for a full reload.
+func (self *JoplinImplementation) LoadChanges(cursor string) (*types.ChangeSet, string, error) {
+	db, done, err := openMozillaDB(self.path)
+	if err != nil {
+		return nil, cursor, err
+	}
+	defer done()
+
+	var since, count int64
+	if cursor != "" {
+		if _, err := fmt.Sscanf(cursor, "%d:%d", &since, &count); err != nil {
+			log.Println(err)
+			cursor = ""
+		}
+	}
+
+	var updated, total, added, others int64
+	err = db.QueryRow(`select ifnull(max(updated_time), 0),
+		ifnull(sum(`+joplinLiveCondition(db)+`), 0),
+		ifnull(sum(created_time > ?), 0) from notes`, since).Scan(&updated, &total, &added)
+	if err != nil {
+		return nil, cursor, err
+	}
+	err = db.QueryRow(`select (select count(*) from folders where updated_time > ?) +
+		(select count(*) from note_tags where updated_time > ?) +
+		(select count(*) from tags where updated_time > ?)`, since, since, since).Scan(&others)
+	if err != nil {
+		return nil, cursor, err
+	}
+	newCursor := fmt.Sprintf("%d:%d", updated, total)
+
+	changeSet := types.NewChangeSet()
+	if cursor == "" || total != count+added || others > 0 {
+		changeSet.Added, err = self.loadNotes(db, "")
+		changeSet.Snapshot = true
+	} else {
+		changeSet.Changed, err = self.loadNotes(db, `and n.updated_time >= ?`, since)
+	}
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	return changeSet, newCursor, nil
+}
+
+// Watch reports a reload whenever Joplin writes the database
+func (self *JoplinImplementation) Watch(changes chan<- *types.ChangeSet, stop <-chan struct{}) error {
+	return watchModTime(self.path, changes, stop)
+}
+
+func (self *JoplinImplementation) loadNotes(db *sql.DB, condition string, args ...any) (map[uint64]*types.Note, error) {
+	data := make(map[uint64]*types.Note)
+
+	folders, err := loadJoplinFolders(db)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := loadJoplinTags(db)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := self.loadResources(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`select n.id, ifnull(n.parent_id, ""), ifnull(n.title, ""),
+		ifnull(n.body, ""), ifnull(n.source_url, ""), n.is_todo, n.todo_due, n.todo_completed,
+		n.markup_language, n.encryption_applied, n.is_conflict, n.user_created_time,
+		n.user_updated_time
+		from notes n where `+joplinLiveCondition(db)+` `+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, parent, title, body, sourceURL string
+		var isTodo, encrypted, isConflict bool
+		var due, completed, created, updated int64
+		var markup int
+		err = rows.Scan(&id, &parent, &title, &body, &sourceURL, &isTodo, &due, &completed,
+			&markup, &encrypted, &isConflict, &created, &updated)
+		if err != nil {
+			return nil, err
+		}
+
+		uuid := stringUUID(id)
+		note := types.NewNote(uuid, title)
+		note.SetFlag(types.FlagReadOnly)
+		note.URI = sourceURL
+		note.Markup = types.Markdown
+		if markup == joplinMarkupHTML {
+			note.Markup = types.MarkupHTML
+		}
+		note.CreatedAt = time.UnixMilli(created)
+		note.ModifiedAt = time.UnixMilli(updated)
+		note.AdditionalProperties = make(map[string]string)
+
+		if encrypted {
+			// Only Joplin has the master key
+			note.SetFlag(types.FlagEncrypted)
+		} else {
+			attachments := make([]string, 0)
+			body = joplinResourceRe.ReplaceAllStringFunc(body, func(ref string) string {
+				path, ok := resources[ref[2:]]
+				if !ok {
+					return ref
+				}
+				if !slices.Contains(attachments, path) {
+					attachments = append(attachments, path)
+				}
+				return (&url.URL{Scheme: "file", Path: path}).String()
+			})
+			note.Set("Body", body, true)
+			if len(attachments) > 0 {
+				note.AdditionalProperties["Attachments"] = strings.Join(attachments, "\n")
+			}
+		}
+
+		if isTodo {
+			note.Type = types.NoteTypeTodoList
+			if completed > 0 {
+				note.AdditionalProperties[types.PropertyCompleted] =
+					time.UnixMilli(completed).Format(time.DateOnly)
+			}
+			if due > 0 {
+				note.AdditionalProperties["Due"] = time.UnixMilli(due).Format("2006-01-02 15:04")
+				if completed == 0 {
+					note.SetFlag(types.FlagNotify)
+				}
+			}
+		}
+
+		notebook := joplinFolderPath(folders, parent)
+		if len(notebook) > 0 {
+			note.AdditionalProperties[joplinNotebookProperty] = strings.Join(notebook, " / ")
+		}
+		if isConflict {
+			// Joplin shows them in a folder of their own
+			notebook = append(notebook, "Conflicts")
+		}
+		for _, tag := range append(notebook, tags[id]...) {
+			if !slices.Contains(note.Tags, tag) {
+				note.Tags = append(note.Tags, tag)
+			}
+		}
+
+		data[uuid] = note
+	}
+
+	return data, rows.Err()
+}
+
+func loadJoplinFolders(db *sql.DB) (map[string]joplinFolder, error) {
+	folders := make(map[string]joplinFolder)
+
+	rows, err := db.Query(`select id, parent_id, title from folders`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var folder joplinFolder
+		if err := rows.Scan(&id, &folder.parent, &folder.title); err != nil {
+			return nil, err
+		}
+		folders[id] = folder
+	}
+
+	return folders, rows.Err()
+}
+
+// joplinFolderPath returns folder titles from the top down to the folder
+// with id
+func joplinFolderPath(folders map[string]joplinFolder, id string) []string {
+	path := make([]string, 0)
+	// Guard against loops in a damaged database
+	for depth := 0; depth < len(folders); depth++ {
+		folder, ok := folders[id]
+		if !ok {
+			break
+		}
+		if folder.title != "" {
+			path = append(path, folder.title)
+		}
+		id = folder.parent
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// loadJoplinTags returns tag titles by note id
+func loadJoplinTags(db *sql.DB) (map[string][]string, error) {
+	tags := make(map[string][]string)
+
+	rows, err := db.Query(`select nt.note_id, t.title from note_tags nt, tags t
+		where nt.tag_id = t.id and t.title != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var noteId, title string
+		if err := rows.Scan(&noteId, &title); err != nil {
+			return nil, err
+		}
+		tags[noteId] = append(tags[noteId], title)
+	}
+
+	return tags, rows.Err()
+}
+
+// loadResources returns paths of the attachment files by resource id, files
+// which are not downloaded yet are left out
+func (self *JoplinImplementation) loadResources(db *sql.DB) (map[string]string, error) {
+	resources := make(map[string]string)
+	dir := filepath.Join(filepath.Dir(self.path), joplinResourcesDir)
+
+	rows, err := db.Query(`select id, file_extension from resources`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, extension string
+		if err := rows.Scan(&id, &extension); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, id)
+		if extension != "" {
+			path += "." + extension
+		}
+		if _, err := os.Stat(path); err == nil {
+			resources[id] = path
+		}
+	}
+
+	return resources, rows.Err()
+}
+
+// joplinLiveCondition leaves out notes in the trash, Joplin 3 keeps deleted
+// notes around instead of removing them
+func joplinLiveCondition(db *sql.DB) string {
+	var count int
+	err := db.QueryRow(`select count(*) from pragma_table_info('notes')
+		where name = 'deleted_time'`).Scan(&count)
+	if err != nil || count == 0 {
+		return "1 = 1"
+	}
+	return "deleted_time = 0"
+}
+
+func (self *JoplinImplementation) PutData(note *types.Note) error {
+	return errors.New("creating Joplin notes is not supported")
+}
+
+func (self *JoplinImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	return errors.New("editing Joplin notes is not supported")
+}
+
+func (self *JoplinImplementation) DeleteData(note *types.Note) error {
+	return errors.New("deleting Joplin notes is not supported")
+}
+
+// GetJoplinFiles returns the databases of Joplin profiles by name
+func GetJoplinFiles() map[string]string {
+	files := make(map[string]string)
+
+	user, _ := user.Current()
+	seen := make(map[string]bool)
+	for _, root := range joplinRoots {
+		baseDir := filepath.Join(user.HomeDir, root.path)
+		profileNames := readJoplinProfileNames(baseDir)
+		// Additional profiles are in profile-<id> directories
+		dirs, _ := filepath.Glob(filepath.Join(baseDir, "profile-*"))
+		for _, dir := range append([]string{baseDir}, dirs...) {
+			databaseFile := filepath.Join(dir, joplinDatabaseFile)
+			resolved, err := filepath.EvalSymlinks(databaseFile)
+			if err != nil || seen[resolved] {
+				continue
+			}
+			seen[resolved] = true
+
+			name := root.label
+			if dir != baseDir {
+				id := strings.TrimPrefix(filepath.Base(dir), "profile-")
+				profileName, ok := profileNames[id]
+				if !ok {
+					profileName = id
+				}
+				name = fmt.Sprintf("%s (%s)", root.label, profileName)
+			}
+			log.Println(name, databaseFile)
+			files[name] = databaseFile
+		}
+	}
+
+	return files
+}
+
+// readJoplinProfileNames returns names the user gave to the profiles by
+// profile id
+func readJoplinProfileNames(baseDir string) map[string]string {
+	names := make(map[string]string)
+
+	content, err := os.ReadFile(filepath.Join(baseDir, "profiles.json"))
+	if err != nil {
+		return names
+	}
+	var config struct {
+		Profiles []struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"profiles"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		log.Println(err)
+		return names
+	}
+	for _, profile := range config.Profiles {
+		if profile.Name != "" {
+			names[profile.Id] = profile.Name
+		}
+	}
+	return names
+}
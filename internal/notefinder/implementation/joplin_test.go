@@ -0,0 +1,182 @@
+package implementation
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"notefinder/internal/notefinder/types"
+)
+
+const joplinSchema = `
+create table folders (id text primary key, parent_id text, title text, updated_time int);
+create table tags (id text primary key, title text, updated_time int);
+create table note_tags (id text primary key, note_id text, tag_id text, updated_time int);
+create table resources (id text primary key, file_extension text);
+create table notes (id text primary key, parent_id text, title text, body text,
+	source_url text, is_todo int default 0, todo_due int default 0,
+	todo_completed int default 0, markup_language int default 1,
+	encryption_applied int default 0, is_conflict int default 0,
+	created_time int, updated_time int, user_created_time int, user_updated_time int%s);
+`
+
+const (
+	joplinPresent = "0123456789abcdef0123456789abcdef"
+	joplinMissing = "fedcba9876543210fedcba9876543210"
+)
+
+// newJoplinFixture creates a database with nested folders, a folder loop,
+// tags, resources and, if trash is set, a note in the trash
+func newJoplinFixture(t *testing.T, trash bool) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, joplinDatabaseFile)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	deletedColumn := ""
+	if trash {
+		deletedColumn = ", deleted_time int default 0"
+	}
+	statements := []string{
+		strings.Replace(joplinSchema, "%s", deletedColumn, 1),
+		`insert into folders values ('top', '', 'Work', 100), ('mid', 'top', 'Projects', 100),
+			('leaf', 'mid', 'Alpha', 100), ('loop1', 'loop2', 'Ping', 100),
+			('loop2', 'loop1', 'Pong', 100)`,
+		`insert into tags values ('t1', 'urgent', 100), ('t2', 'later', 100)`,
+		`insert into note_tags values ('nt1', 'n1', 't1', 100), ('nt2', 'n1', 't2', 100)`,
+		`insert into resources values ('` + joplinPresent + `', 'png'), ('` + joplinMissing + `', 'pdf')`,
+		`insert into notes (id, parent_id, title, body, created_time, updated_time,
+			user_created_time, user_updated_time) values
+			('n1', 'leaf', 'Plan', 'See ![chart](:/` + joplinPresent + `) and [spec](:/` +
+			joplinMissing + `)', 100, 100, 100, 100),
+			('n2', 'loop1', 'Looped', 'text', 100, 100, 100, 100)`,
+	}
+	if trash {
+		statements = append(statements, `insert into notes (id, parent_id, title, body,
+			created_time, updated_time, user_created_time, user_updated_time, deleted_time)
+			values ('n3', 'top', 'Trashed', 'gone', 100, 100, 100, 100, 200)`)
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resources := filepath.Join(dir, joplinResourcesDir)
+	if err := os.Mkdir(resources, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(resources, joplinPresent+".png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func joplinNoteByTitle(t *testing.T, data map[uint64]*types.Note, title string) *types.Note {
+	t.Helper()
+	for _, note := range data {
+		if note.Title == title {
+			return note
+		}
+	}
+	t.Fatalf("no note %q in %v", title, data)
+	return nil
+}
+
+func TestJoplinLoadData(t *testing.T) {
+	for _, trash := range []bool{false, true} {
+		path := newJoplinFixture(t, trash)
+		data, err := NewJoplinImplementation(map[string]string{"path": path}).LoadData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 2 {
+			t.Errorf("trash %v: loaded %d notes, want 2 without the trashed one", trash, len(data))
+		}
+
+		plan := joplinNoteByTitle(t, data, "Plan")
+		if got := plan.AdditionalProperties[joplinNotebookProperty]; got != "Work / Projects / Alpha" {
+			t.Errorf("notebook = %q", got)
+		}
+		if want := []string{"Work", "Projects", "Alpha", "urgent", "later"}; !slices.Equal(plan.Tags, want) {
+			t.Errorf("tags = %v, want %v", plan.Tags, want)
+		}
+
+		resource := filepath.Join(filepath.Dir(path), joplinResourcesDir, joplinPresent+".png")
+		fileURL := (&url.URL{Scheme: "file", Path: resource}).String()
+		if want := "See ![chart](" + fileURL + ") and [spec](:/" + joplinMissing + ")"; plan.Body != want {
+			t.Errorf("body = %q, want %q", plan.Body, want)
+		}
+		if got := plan.AdditionalProperties["Attachments"]; got != resource {
+			t.Errorf("attachments = %q, want %q", got, resource)
+		}
+
+		// The folder loop ends after as many steps as there are folders
+		looped := joplinNoteByTitle(t, data, "Looped")
+		if got := looped.AdditionalProperties[joplinNotebookProperty]; got != "Ping / Pong / Ping / Pong / Ping" {
+			t.Errorf("looped notebook = %q", got)
+		}
+		if want := []string{"Ping", "Pong"}; !slices.Equal(looped.Tags, want) {
+			t.Errorf("looped tags = %v, want %v", looped.Tags, want)
+		}
+	}
+}
+
+func TestJoplinLoadChangesAfterDelete(t *testing.T) {
+	for _, trash := range []bool{false, true} {
+		path := newJoplinFixture(t, trash)
+		impl := NewJoplinImplementation(map[string]string{"path": path})
+
+		changeSet, cursor, err := impl.LoadChanges("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !changeSet.Snapshot || len(changeSet.Added) != 2 {
+			t.Fatalf("first load: snapshot %v with %d notes", changeSet.Snapshot, len(changeSet.Added))
+		}
+
+		changeSet, cursor, err = impl.LoadChanges(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Notes updated at the cursor time are read again, nothing more
+		if changeSet.Snapshot || len(changeSet.Added) > 0 {
+			t.Errorf("unchanged database: snapshot %v with %d added", changeSet.Snapshot,
+				len(changeSet.Added))
+		}
+
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statement := `delete from notes where id = 'n2'`
+		if trash {
+			// Joplin 3 moves deleted notes to the trash
+			statement = `update notes set deleted_time = 300 where id = 'n2'`
+		}
+		_, err = db.Exec(statement)
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changeSet, _, err = impl.LoadChanges(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !changeSet.Snapshot {
+			t.Errorf("trash %v: no snapshot after a delete", trash)
+		}
+		if len(changeSet.Added) != 1 || joplinNoteByTitle(t, changeSet.Added, "Plan") == nil {
+			t.Errorf("trash %v: snapshot has %v, want Plan only", trash, changeSet.Added)
+		}
+	}
+}
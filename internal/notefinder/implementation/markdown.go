@@ -0,0 +1,490 @@
+package implementation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+A folder of Markdown files as kept by Obsidian, Zettlr and friends. Metadata
+lives in the YAML front matter of every file:
+
+	---
+	title: Meeting notes
+	tags: [work, infra]
+	created: 2024-05-01 10:00
+	starred: true
+	---
+
+Keys not known here are exposed as additional properties and kept intact on
+writes. Without a title the file name is the title.
+*/
+const (
+	markdownExt         = ".md"
+	frontMatterMark     = "---"
+	frontMatterTimeUsed = "2006-01-02T15:04:05Z07:00"
+)
+
+var (
+	// Keys mapped onto note fields rather than additional properties
+	frontMatterKeys = map[string]bool{
+		"title": true, "tags": true, "tag": true, "created": true, "date": true,
+		"starred": true, "archived": true,
+	}
+	frontMatterTimeLayouts = []string{frontMatterTimeUsed, "2006-01-02T15:04:05",
+		"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+)
+
+type MarkdownImplementation struct {
+	path string
+}
+
+func NewMarkdownImplementation(config map[string]string) *MarkdownImplementation {
+	return &MarkdownImplementation{path: config["path"]}
+}
+
+func (self *MarkdownImplementation) CanWrite() (bool, error) {
+	file, err := os.CreateTemp(self.path, tempFilePattern)
+	if err != nil {
+		return false, err
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	return true, nil
+}
+
+func (self *MarkdownImplementation) SupportedProperties() map[string]types.Writable {
+	return map[string]types.Writable{"Title": true, "URI": false, "Body": true,
+		"Tags": true, "Starred": true, "Archived": true}
+}
+
+func (self *MarkdownImplementation) LoadData() (map[uint64]*types.Note, error) {
+	data := make(map[uint64]*types.Note, 0)
+
+	err := filepath.WalkDir(self.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == self.path {
+				return err
+			}
+			log.Println(err)
+			return nil
+		}
+		// Application settings and trash, e.g. .obsidian and .trash
+		if d.IsDir() && path != self.path && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || filepath.Ext(path) != markdownExt || tempFileRe.MatchString(d.Name()) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(self.path, path)
+		if err != nil {
+			return err
+		}
+		note, err := self.readNote(relPath)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		data[note.UUID] = note
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// readNote loads the file, its UUID comes from the path so that rewriting
+// the file does not change it
+func (self *MarkdownImplementation) readNote(relPath string) (*types.Note, error) {
+	path := filepath.Join(self.path, relPath)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	frontMatter, body, err := splitFrontMatter(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	note := types.NewNote(stringUUID(relPath),
+		strings.TrimSuffix(filepath.Base(relPath), markdownExt))
+	note.Set("Body", body, true)
+	note.Markup = types.Markdown
+	note.CreatedAt = birthTime(path, info)
+	note.ModifiedAt = info.ModTime()
+	note.AdditionalProperties = map[string]string{pathProperty: relPath}
+
+	if frontMatter == nil {
+		return note, nil
+	}
+	for i := 0; i+1 < len(frontMatter.Content); i += 2 {
+		key, value := frontMatter.Content[i].Value, frontMatter.Content[i+1]
+		switch key {
+		case "title":
+			if value.Value != "" {
+				note.Title = value.Value
+			}
+		case "tags", "tag":
+			note.Tags = append(note.Tags, frontMatterTags(value)...)
+		case "created", "date":
+			if created, ok := frontMatterTime(value.Value); ok {
+				note.CreatedAt = created
+			}
+		case "starred":
+			if frontMatterBool(value) {
+				note.SetFlag(types.FlagStarred)
+			}
+		case "archived":
+			if frontMatterBool(value) {
+				note.SetFlag(types.FlagArchived)
+			}
+		default:
+			if key != pathProperty {
+				note.AdditionalProperties[key] = frontMatterString(value)
+			}
+		}
+	}
+
+	return note, nil
+}
+
+// splitFrontMatter returns the front matter mapping, nil if there is none,
+// and the rest of the file
+func splitFrontMatter(content []byte) (*yaml.Node, string, error) {
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	if !strings.HasPrefix(text, frontMatterMark+"\n") {
+		return nil, text, nil
+	}
+	rest := text[len(frontMatterMark)+1:]
+
+	var yamlText, body string
+	if strings.HasPrefix(rest, frontMatterMark+"\n") || rest == frontMatterMark {
+		body = strings.TrimPrefix(rest, frontMatterMark)
+	} else {
+		end := strings.Index(rest, "\n"+frontMatterMark+"\n")
+		if end < 0 {
+			if !strings.HasSuffix(rest, "\n"+frontMatterMark) {
+				return nil, text, nil
+			}
+			end = len(rest) - len(frontMatterMark) - 1
+		}
+		yamlText = rest[:end]
+		body = rest[min(end+len(frontMatterMark)+1, len(rest)):]
+	}
+	body = strings.TrimPrefix(body, "\n")
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(yamlText), &doc); err != nil {
+		return nil, "", err
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, body, nil
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, "", errors.New("front matter is not a mapping")
+	}
+	return doc.Content[0], body, nil
+}
+
+func frontMatterTags(value *yaml.Node) []string {
+	var tags []string
+	if value.Kind == yaml.SequenceNode {
+		for _, item := range value.Content {
+			tags = append(tags, item.Value)
+		}
+	} else {
+		tags = strings.FieldsFunc(value.Value, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+
+	ret := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = strings.TrimPrefix(strings.TrimSpace(tag), "#"); tag != "" {
+			ret = append(ret, tag)
+		}
+	}
+	return ret
+}
+
+func frontMatterTime(value string) (time.Time, bool) {
+	for _, layout := range frontMatterTimeLayouts {
+		if ret, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return ret, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func frontMatterBool(value *yaml.Node) bool {
+	ret, err := strconv.ParseBool(value.Value)
+	return err == nil && ret
+}
+
+// frontMatterString flattens the value for searching and display
+func frontMatterString(value *yaml.Node) string {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return value.Value
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(value.Content))
+		for _, item := range value.Content {
+			items = append(items, frontMatterString(item))
+		}
+		return strings.Join(items, ", ")
+	}
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (self *MarkdownImplementation) PutData(note *types.Note) error {
+	note.Title = normalizeTitle(note.Title)
+	if note.Title == "" {
+		return errors.New("title cannot be empty")
+	}
+	relPath := note.Title + markdownExt
+	path := filepath.Join(self.path, relPath)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("\"%s\" already exists, cannot create new item", note.Title)
+	}
+
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = time.Now()
+	}
+	frontMatter := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setFrontMatter(frontMatter, note, nil, note.Title)
+	content, err := renderFrontMatter(frontMatter, note.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, content, 0644); err != nil {
+		log.Println(err)
+		return err
+	}
+	note.UUID = stringUUID(relPath)
+	if note.AdditionalProperties == nil {
+		note.AdditionalProperties = make(map[string]string)
+	}
+	note.AdditionalProperties[pathProperty] = relPath
+	return nil
+}
+
+// UpdateData rewrites the file keeping front matter keys it does not know
+// about. The file is renamed when the title changes, unless the title is
+// kept in the front matter.
+func (self *MarkdownImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	relPath, ok := oldNote.AdditionalProperties[pathProperty]
+	if !ok {
+		return errors.New("note does not belong to the vault")
+	}
+	path := filepath.Join(self.path, relPath)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !oldNote.ModifiedAt.IsZero() && !info.ModTime().Equal(oldNote.ModifiedAt) {
+		return fmt.Errorf("%w: \"%s\" was modified on disk", ErrConflict, oldNote.Title)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	frontMatter, _, err := splitFrontMatter(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if frontMatter == nil {
+		frontMatter = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+
+	newNote.Title = normalizeTitle(newNote.Title)
+	if newNote.Title == "" {
+		return errors.New("title cannot be empty")
+	}
+	newRelPath := relPath
+	fileTitle := strings.TrimSuffix(filepath.Base(relPath), markdownExt)
+	if yamlValue(frontMatter, "title") == nil && newNote.Title != fileTitle {
+		newRelPath = filepath.Join(filepath.Dir(relPath), newNote.Title+markdownExt)
+		fileTitle = newNote.Title
+	}
+	setFrontMatter(frontMatter, newNote, oldNote, fileTitle)
+
+	newContent, err := renderFrontMatter(frontMatter, newNote.Body)
+	if err != nil {
+		return err
+	}
+
+	newPath := filepath.Join(self.path, newRelPath)
+	if newPath != path {
+		if _, err := os.Stat(newPath); err == nil {
+			return fmt.Errorf("\"%s\" already exists, cannot rename", newNote.Title)
+		}
+	}
+	if err := writeFileAtomic(newPath, newContent, info.Mode().Perm()); err != nil {
+		log.Println(err)
+		return err
+	}
+	if newPath != path {
+		if err := os.Remove(path); err != nil {
+			log.Println(err)
+		}
+	}
+
+	newNote.UUID = stringUUID(newRelPath)
+	if info, err := os.Stat(newPath); err == nil {
+		newNote.ModifiedAt = info.ModTime()
+	}
+	if newNote.AdditionalProperties == nil {
+		newNote.AdditionalProperties = make(map[string]string)
+	}
+	newNote.AdditionalProperties[pathProperty] = newRelPath
+	return nil
+}
+
+// setFrontMatter writes the note fields into the front matter. Properties
+// are only written if they changed since oldNote, so values which are not
+// plain strings survive.
+func setFrontMatter(frontMatter *yaml.Node, note *types.Note, oldNote *types.Note, fileTitle string) {
+	if note.Title != fileTitle || yamlValue(frontMatter, "title") != nil {
+		yamlSet(frontMatter, "title", yamlScalar(note.Title))
+	}
+
+	if yamlValue(frontMatter, "tag") != nil {
+		yamlDelete(frontMatter, "tag")
+	}
+	if len(note.Tags) > 0 {
+		tags := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+		for _, tag := range note.Tags {
+			tags.Content = append(tags.Content, yamlScalar(tag))
+		}
+		yamlSet(frontMatter, "tags", tags)
+	} else {
+		yamlDelete(frontMatter, "tags")
+	}
+
+	if yamlValue(frontMatter, "created") == nil && yamlValue(frontMatter, "date") == nil &&
+		!note.CreatedAt.IsZero() {
+		yamlSet(frontMatter, "created", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!timestamp",
+			Value: note.CreatedAt.Format(frontMatterTimeUsed)})
+	}
+
+	for key, flag := range map[string]uint32{"starred": types.FlagStarred,
+		"archived": types.FlagArchived} {
+		if note.FlagIsSet(flag) {
+			yamlSet(frontMatter, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+		} else {
+			yamlDelete(frontMatter, key)
+		}
+	}
+
+	keys := make([]string, 0, len(note.AdditionalProperties))
+	for key := range note.AdditionalProperties {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		value := note.AdditionalProperties[key]
+		if key == pathProperty || frontMatterKeys[key] {
+			continue
+		}
+		if oldNote != nil {
+			if oldValue, ok := oldNote.AdditionalProperties[key]; ok && oldValue == value {
+				continue
+			}
+		}
+		yamlSet(frontMatter, key, yamlScalar(value))
+	}
+	if oldNote != nil {
+		for key := range oldNote.AdditionalProperties {
+			if _, ok := note.AdditionalProperties[key]; !ok && key != pathProperty {
+				yamlDelete(frontMatter, key)
+			}
+		}
+	}
+}
+
+func renderFrontMatter(frontMatter *yaml.Node, body string) ([]byte, error) {
+	if len(frontMatter.Content) == 0 {
+		return []byte(body), nil
+	}
+
+	var out bytes.Buffer
+	out.WriteString(frontMatterMark + "\n")
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(frontMatter); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	out.WriteString(frontMatterMark + "\n")
+	out.WriteString(body)
+	return out.Bytes(), nil
+}
+
+func yamlScalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func yamlValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func yamlSet(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, yamlScalar(key), value)
+}
+
+func yamlDelete(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = slices.Delete(mapping.Content, i, i+2)
+			return
+		}
+	}
+}
+
+func (self *MarkdownImplementation) DeleteData(note *types.Note) error {
+	relPath, ok := note.AdditionalProperties[pathProperty]
+	if !ok {
+		return errors.New("note does not belong to the vault")
+	}
+	return os.Remove(filepath.Join(self.path, relPath))
+}
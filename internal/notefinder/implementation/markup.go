@@ -0,0 +1,119 @@
+package implementation
+
+import (
+	"regexp"
+	"strings"
+)
+
+/*
+Conversion of the Markdown used by the viewer into markup of other note
+applications. Only the subset those applications can express is handled,
+anything else is kept as text.
+*/
+
+var (
+	markdownInlineRe = regexp.MustCompile("`([^`]+)`" +
+		`|\[\[([^\]|]+)(?:\|([^\]]*))?\]\]` +
+		`|\[([^\]]*)\]\(([^)\s]+)\)` +
+		`|<((?:https?|ftp|file|mailto):[^>\s]+)>` +
+		`|\*\*(.+?)\*\*` +
+		`|~~(.+?)~~` +
+		`|==(.+?)==` +
+		`|\*([^*\s](?:[^*]*[^*\s])?)\*` +
+		`|\b_([^_\s](?:[^_]*[^_\s])?)_\b`)
+	markdownListRe    = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(?:\[([ xX])\]\s+)?(.*)$`)
+	markdownHeadingRe = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+)
+
+// inlineSyntax spells inline markup in the target format
+type inlineSyntax struct {
+	escape                          func(string) string
+	code                            func(string) string
+	link                            func(text string, url string) string
+	wikiLink                        func(target string, label string) string
+	bold, italic, strike, highlight [2]string
+}
+
+// markdownToInline converts inline Markdown of a single line
+func markdownToInline(text string, syntax inlineSyntax) string {
+	var out strings.Builder
+	last := 0
+	for _, match := range markdownInlineRe.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(syntax.escape(text[last:match[0]]))
+		last = match[1]
+
+		group := func(n int) (string, bool) {
+			if match[2*n] < 0 {
+				return "", false
+			}
+			return text[match[2*n]:match[2*n+1]], true
+		}
+		wrap := func(marks [2]string, inner string) {
+			out.WriteString(marks[0] + markdownToInline(inner, syntax) + marks[1])
+		}
+
+		if code, ok := group(1); ok {
+			out.WriteString(syntax.code(code))
+		} else if target, ok := group(2); ok {
+			label, _ := group(3)
+			out.WriteString(syntax.wikiLink(target, label))
+		} else if url, ok := group(5); ok {
+			label, _ := group(4)
+			out.WriteString(syntax.link(label, url))
+		} else if url, ok := group(6); ok {
+			out.WriteString(syntax.link(url, url))
+		} else if inner, ok := group(7); ok {
+			wrap(syntax.bold, inner)
+		} else if inner, ok := group(8); ok {
+			wrap(syntax.strike, inner)
+		} else if inner, ok := group(9); ok {
+			wrap(syntax.highlight, inner)
+		} else if inner, ok := group(10); ok {
+			wrap(syntax.italic, inner)
+		} else if inner, ok := group(11); ok {
+			wrap(syntax.italic, inner)
+		}
+	}
+	out.WriteString(syntax.escape(text[last:]))
+	return out.String()
+}
+
+type markdownListItem struct {
+	depth   int
+	bullet  string
+	ordered bool
+	// ' ', 'x' or 0 when the item has no checkbox
+	checkbox byte
+	text     string
+}
+
+// parseMarkdownListItem recognizes list items, nesting is counted in steps
+// of two spaces or a tab
+func parseMarkdownListItem(line string) (markdownListItem, bool) {
+	match := markdownListRe.FindStringSubmatch(line)
+	if match == nil {
+		return markdownListItem{}, false
+	}
+	indent := strings.ReplaceAll(match[1], "\t", "  ")
+	item := markdownListItem{depth: len(indent) / 2, bullet: match[2], text: match[4],
+		ordered: match[2][0] >= '0' && match[2][0] <= '9'}
+	if match[3] != "" {
+		item.checkbox = strings.ToLower(match[3])[0]
+	}
+	return item, true
+}
+
+// wrapLines puts marks around every line of text, Markdown emphasis cannot
+// span lines. Surrounding spaces stay outside of the marks.
+func wrapLines(text string, open string, close string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		start := strings.Index(line, trimmed)
+		lines[i] = line[:start] + open + trimmed + close + line[start+len(trimmed):]
+	}
+	return strings.Join(lines, "\n")
+}
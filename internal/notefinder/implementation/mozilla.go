@@ -3,13 +3,13 @@ package implementation
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/user"
 	"path/filepath"
-	"regexp"
-	"strings"
+	"slices"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
@@ -22,14 +22,38 @@ https://www.codejam.info/2021/10/bypass-sqlite-exclusive-lock.html
 */
 const (
 	bypassExclusiveLock = true
+
+	mozillaPollInterval = 2 * time.Second
+
+	mozillaTagsRoot = "tags________"
 )
 
+// Built-in folders which are not worth a tag
+var mozillaRootFolders = map[string]bool{
+	"root________":  true,
+	"menu________":  true,
+	"toolbar_____":  true,
+	"unfiled_____":  true,
+	"mobile______":  true,
+	mozillaTagsRoot: true,
+}
+
 type MozillaImplementation struct {
 	path string
+	// Browsing history instead of bookmarks, see mozilla_history.go
+	history    bool
+	historyAge time.Duration
+	favicons   *mozillaFavicons
 }
 
 func NewMozillaImplementation(config map[string]string) *MozillaImplementation {
-	return &MozillaImplementation{path: config["path"]}
+	self := &MozillaImplementation{path: config["path"],
+		favicons: newMozillaFavicons(filepath.Join(filepath.Dir(config["path"]), faviconsFileName))}
+	if config["mode"] == "history" {
+		self.history = true
+		self.historyAge = historyAge(config)
+	}
+	return self
 }
 
 func (self *MozillaImplementation) CanWrite() (bool, error) {
@@ -41,36 +65,148 @@ func (self *MozillaImplementation) SupportedProperties() map[string]types.Writab
 }
 
 func (self *MozillaImplementation) LoadData() (map[uint64]*types.Note, error) {
-	data := make(map[uint64]*types.Note, 0)
+	db, done, err := self.open()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if self.history {
+		return loadHistory(db, self.historySince())
+	}
+	return loadBookmarks(db, "")
+}
+
+// LoadChanges loads bookmarks modified since the cursor, which holds the
+// latest modification time and the number of bookmarks seen. If the number
+// does not add up, something was deleted and everything is loaded again.
+func (self *MozillaImplementation) LoadChanges(cursor string) (*types.ChangeSet, string, error) {
+	db, done, err := self.open()
+	if err != nil {
+		return nil, cursor, err
+	}
+	defer done()
+
+	if self.history {
+		return self.loadHistoryChanges(db, cursor)
+	}
+
+	var since, count int64
+	if cursor != "" {
+		if _, err := fmt.Sscanf(cursor, "%d:%d", &since, &count); err != nil {
+			log.Println(err)
+			cursor = ""
+		}
+	}
+
+	var lastModified, total, added, folders int64
+	err = db.QueryRow(`select ifnull(max(b.lastModified), 0), count(*),
+		ifnull(sum(b.dateAdded > ?), 0)
+		from moz_bookmarks b, moz_places p where b.fk = p.id`, since).Scan(
+		&lastModified, &total, &added)
+	if err != nil {
+		return nil, cursor, err
+	}
+	// Renaming or moving a folder does not touch the bookmarks inside
+	err = db.QueryRow(`select count(*) from moz_bookmarks
+		where type = 2 and lastModified > ?`, since).Scan(&folders)
+	if err != nil {
+		return nil, cursor, err
+	}
+	newCursor := fmt.Sprintf("%d:%d", lastModified, total)
+
+	changeSet := types.NewChangeSet()
+	if cursor == "" || total != count+added || folders > 0 {
+		changeSet.Added, err = loadBookmarks(db, "")
+		changeSet.Snapshot = true
+	} else {
+		// Timestamps are not unique, so the latest ones are loaded again
+		// Tagging adds an entry for the same place rather than modifying
+		// the bookmark itself
+		changeSet.Changed, err = loadBookmarks(db, `and b.fk in
+			(select fk from moz_bookmarks where lastModified >= ?)`, since)
+	}
+	if err != nil {
+		return nil, cursor, err
+	}
 
+	return changeSet, newCursor, nil
+}
+
+func (self *MozillaImplementation) open() (*sql.DB, func(), error) {
+	return openMozillaDB(self.path)
+}
+
+// openMozillaDB returns the database and a function to call when done with
+// it. Firefox keeps its databases locked while running.
+func openMozillaDB(path string) (*sql.DB, func(), error) {
 	var fileName string
+	cleanup := func() {}
 	if !bypassExclusiveLock {
 		file, err := ioutil.TempFile("/tmp/", "nf.*.sqlite")
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer os.Remove(file.Name())
+		cleanup = func() { os.Remove(file.Name()) }
 
-		bytes, err := ioutil.ReadFile(self.path)
+		bytes, err := ioutil.ReadFile(path)
 		err = ioutil.WriteFile(file.Name(), bytes, 0644)
 
 		fileName = file.Name()
 	} else {
-		fileName = "file:" + self.path + "?immutable=1"
+		fileName = "file:" + path + "?immutable=1"
 	}
+
 	db, err := sql.Open("sqlite3", fileName)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return db, func() {
+		db.Close()
+		cleanup()
+	}, nil
+}
+
+func loadBookmarks(db *sql.DB, condition string, args ...any) (map[uint64]*types.Note, error) {
+	data := make(map[uint64]*types.Note, 0)
+
+	folders, err := loadFolders(db)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := loadTags(db)
+	if err != nil {
+		return nil, err
+	}
+	keywords, err := loadKeywords(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tag entries are bookmarks too, but they live under the tags root
+	query := `select b.id, ifnull(b.parent, 0), p.id, ifnull(b.title, ""), p.url,
+		ifnull(p.description, ""), ifnull(b.dateAdded, 0), ifnull(b.lastModified, 0)
+		from moz_bookmarks b, moz_places p where b.fk = p.id
+		and ifnull(b.parent, 0) not in (select f.id from moz_bookmarks f, moz_bookmarks r
+			where f.parent = r.id and r.guid = '` + mozillaTagsRoot + `') ` + condition
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	query := `select b.id, b.title, p.url, ifnull(p.description, "")
-		from moz_bookmarks b, moz_places p where b.fk = p.id`
-	rows, _ := db.Query(query)
 	for rows.Next() {
 		var id int
+		var parent, placeId int64
 		var title string
 		var url string
 		var description string
-		err = rows.Scan(&id, &title, &url, &description)
+		var dateAdded, lastModified int64
+		err = rows.Scan(&id, &parent, &placeId, &title, &url, &description,
+			&dateAdded, &lastModified)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
 		note := types.NewNote(uint64(id), title)
@@ -78,59 +214,177 @@ func (self *MozillaImplementation) LoadData() (map[uint64]*types.Note, error) {
 		note.SetFlag(types.FlagReadOnly)
 		note.URI = url
 		note.Type = types.NoteTypeBookmark
+		note.CreatedAt = mozillaTime(dateAdded)
+		note.ModifiedAt = mozillaTime(lastModified)
+
+		for _, tag := range append(folderPath(folders, parent), tags[placeId]...) {
+			if !slices.Contains(note.Tags, tag) {
+				note.Tags = append(note.Tags, tag)
+			}
+		}
+		if keyword, ok := keywords[placeId]; ok {
+			note.AdditionalProperties = map[string]string{"Keyword": keyword}
+		}
 
 		data[uint64(id)] = note
 	}
 
-	return data, err
+	return data, rows.Err()
 }
 
-func (self *MozillaImplementation) PutData(note *types.Note) error {
-	return errors.New("Creating bookmarks is not currently supported")
+type mozillaFolder struct {
+	parent int64
+	title  string
+	guid   string
 }
 
-func (self *MozillaImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
-	return errors.New("Editing bookmarks is not currently supported")
+func loadFolders(db *sql.DB) (map[int64]mozillaFolder, error) {
+	folders := make(map[int64]mozillaFolder)
+
+	rows, err := db.Query(`select id, ifnull(parent, 0), ifnull(title, ""), ifnull(guid, "")
+		from moz_bookmarks where type = 2`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var folder mozillaFolder
+		if err := rows.Scan(&id, &folder.parent, &folder.title, &folder.guid); err != nil {
+			return nil, err
+		}
+		folders[id] = folder
+	}
+
+	return folders, rows.Err()
 }
 
-func (self *MozillaImplementation) DeleteData(note *types.Note) error {
-	return errors.New("Deleting bookmarks is not currently supported")
+// folderPath returns folder titles from the top down to the folder with id
+func folderPath(folders map[int64]mozillaFolder, id int64) []string {
+	path := make([]string, 0)
+	// Guard against loops in a damaged database
+	for depth := 0; depth < len(folders); depth++ {
+		folder, ok := folders[id]
+		if !ok || mozillaRootFolders[folder.guid] {
+			break
+		}
+		if folder.title != "" {
+			path = append(path, folder.title)
+		}
+		id = folder.parent
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// loadTags returns Firefox tags by place id. A tag is a folder under the
+// tags root holding a bookmark entry for every tagged place.
+func loadTags(db *sql.DB) (map[int64][]string, error) {
+	tags := make(map[int64][]string)
+
+	rows, err := db.Query(`select b.fk, t.title
+		from moz_bookmarks b, moz_bookmarks t, moz_bookmarks r
+		where b.parent = t.id and t.parent = r.id and r.guid = ?
+		and b.fk is not null and t.title is not null`, mozillaTagsRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var placeId int64
+		var tag string
+		if err := rows.Scan(&placeId, &tag); err != nil {
+			return nil, err
+		}
+		tags[placeId] = append(tags[placeId], tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// loadKeywords returns the keywords by place id, space separated if there
+// are several
+func loadKeywords(db *sql.DB) (map[int64]string, error) {
+	keywords := make(map[int64]string)
+
+	rows, err := db.Query(`select place_id, keyword from moz_keywords
+		where place_id is not null order by keyword`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var placeId int64
+		var keyword string
+		if err := rows.Scan(&placeId, &keyword); err != nil {
+			return nil, err
+		}
+		if keywords[placeId] != "" {
+			keyword = keywords[placeId] + " " + keyword
+		}
+		keywords[placeId] = keyword
+	}
+
+	return keywords, rows.Err()
 }
 
-func GetMozillaFiles() map[string]string {
-	files := make(map[string]string, 0)
+// Mozilla keeps timestamps in microseconds since the epoch
+func mozillaTime(microseconds int64) time.Time {
+	if microseconds == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(microseconds)
+}
 
-	for _, path := range []string{".mozilla/firefox",
-		"Library/Application Support/Firefox/Profiles"} {
-		user, _ := user.Current()
-		baseDir := filepath.Join(user.HomeDir, path)
+// Watch reports a reload whenever Firefox touches places.sqlite
+func (self *MozillaImplementation) Watch(changes chan<- *types.ChangeSet, stop <-chan struct{}) error {
+	return watchModTime(self.path, changes, stop)
+}
 
-		items, err := os.ReadDir(baseDir)
-		if err == nil {
-			for _, f := range items {
-				if !f.IsDir() || !strings.Contains(f.Name(), "default") {
-					continue
-				}
+// watchModTime polls the file and reports a reload whenever it is modified,
+// for files which are rewritten as a whole or locked by the browser
+func watchModTime(path string, changes chan<- *types.ChangeSet, stop <-chan struct{}) error {
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
 
-				placesFile := filepath.Join(baseDir, f.Name(), "places.sqlite")
+	ticker := time.NewTicker(mozillaPollInterval)
+	defer ticker.Stop()
 
-				_, err := os.Stat(placesFile)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-				re := regexp.MustCompile(`/([^/]+)\.default(?:-release)?/`)
-				matches := re.FindStringSubmatch(placesFile)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastModified) {
+				continue
+			}
+			lastModified = info.ModTime()
 
-				if len(matches) > 1 {
-					log.Println(placesFile)
-					files[matches[1]] = placesFile
-				}
+			changeSet := types.NewChangeSet()
+			changeSet.Reload = true
+			select {
+			case changes <- changeSet:
+			case <-stop:
+				return nil
 			}
-		} else {
-			log.Println(err)
 		}
 	}
+}
+
+func (self *MozillaImplementation) PutData(note *types.Note) error {
+	return errors.New("Creating bookmarks is not currently supported")
+}
 
-	return files
+func (self *MozillaImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	return errors.New("Editing bookmarks is not currently supported")
+}
+
+func (self *MozillaImplementation) DeleteData(note *types.Note) error {
+	return errors.New("Deleting bookmarks is not currently supported")
 }
@@ -0,0 +1,116 @@
+package implementation
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+History mode is enabled per notebook in the config:
+
+	[Firefox history]
+	impl = mozilla
+	path = /home/user/.mozilla/firefox/xxxxxxxx.default-release/places.sqlite
+	mode = history
+	history_days = 30
+
+Only pages visited within the last history_days days are loaded.
+*/
+const (
+	defaultHistoryDays = 30
+)
+
+func historyAge(config map[string]string) time.Duration {
+	days := defaultHistoryDays
+	if value, ok := config["history_days"]; ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			log.Printf("invalid history_days \"%s\", using %d", value, defaultHistoryDays)
+		} else {
+			days = parsed
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// historySince returns the oldest visit time to load, in microseconds
+func (self *MozillaImplementation) historySince() int64 {
+	return time.Now().Add(-self.historyAge).UnixMicro()
+}
+
+func loadHistory(db *sql.DB, since int64) (map[uint64]*types.Note, error) {
+	data := make(map[uint64]*types.Note, 0)
+
+	rows, err := db.Query(`select p.id, ifnull(p.title, ""), p.url, ifnull(p.description, ""),
+		ifnull(p.visit_count, 0), ifnull(p.frecency, 0), ifnull(p.last_visit_date, 0),
+		ifnull((select min(v.visit_date) from moz_historyvisits v where v.place_id = p.id), 0)
+		from moz_places p
+		where p.last_visit_date >= ? and ifnull(p.hidden, 0) = 0`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var title, url, description string
+		var visitCount, frecency, lastVisit, firstVisit int64
+		err = rows.Scan(&id, &title, &url, &description, &visitCount, &frecency,
+			&lastVisit, &firstVisit)
+		if err != nil {
+			return nil, err
+		}
+		if title == "" {
+			title = url
+		}
+
+		note := types.NewNote(uint64(id), title)
+		note.Set("Body", description, true)
+		note.SetFlag(types.FlagReadOnly)
+		note.URI = url
+		note.Type = types.NoteTypeBookmark
+		note.CreatedAt = mozillaTime(firstVisit)
+		note.ModifiedAt = mozillaTime(lastVisit)
+		note.AdditionalProperties = map[string]string{
+			"VisitCount": strconv.FormatInt(visitCount, 10),
+			"LastVisit":  note.ModifiedAt.Format(time.RFC3339),
+			"Frecency":   strconv.FormatInt(frecency, 10),
+		}
+
+		data[uint64(id)] = note
+	}
+
+	return data, rows.Err()
+}
+
+// loadHistoryChanges reloads the history window whenever the latest visit
+// or the number of pages in the window changes, the latter happens as old
+// visits fall out of the window
+func (self *MozillaImplementation) loadHistoryChanges(db *sql.DB, cursor string) (*types.ChangeSet, string, error) {
+	since := self.historySince()
+
+	var lastVisit, count int64
+	err := db.QueryRow(`select ifnull(max(last_visit_date), 0), count(*) from moz_places
+		where last_visit_date >= ? and ifnull(hidden, 0) = 0`, since).Scan(&lastVisit, &count)
+	if err != nil {
+		return nil, cursor, err
+	}
+	newCursor := fmt.Sprintf("%d:%d", lastVisit, count)
+
+	changeSet := types.NewChangeSet()
+	if newCursor == cursor {
+		return changeSet, cursor, nil
+	}
+
+	changeSet.Added, err = loadHistory(db, since)
+	if err != nil {
+		return nil, cursor, err
+	}
+	changeSet.Snapshot = true
+	return changeSet, newCursor, nil
+}
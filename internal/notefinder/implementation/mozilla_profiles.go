@@ -0,0 +1,151 @@
+package implementation
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"gopkg.in/ini.v1"
+)
+
+type mozillaRoot struct {
+	// Relative to the home directory
+	path  string
+	label string
+}
+
+// Places where Firefox and its forks keep profiles.ini
+var mozillaProfileRoots = []mozillaRoot{
+	{".mozilla/firefox", "Firefox"},
+	{"snap/firefox/common/.mozilla/firefox", "Firefox Snap"},
+	{".var/app/org.mozilla.firefox/.mozilla/firefox", "Firefox Flatpak"},
+	{".librewolf", "LibreWolf"},
+	{".var/app/io.gitlab.librewolf-community/.librewolf", "LibreWolf Flatpak"},
+	{".waterfox", "Waterfox"},
+	{".var/app/net.waterfox.waterfox/.waterfox", "Waterfox Flatpak"},
+	{"Library/Application Support/Firefox", "Firefox"},
+	{"Library/Application Support/librewolf", "LibreWolf"},
+	{"Library/Application Support/Waterfox", "Waterfox"},
+}
+
+type mozillaProfile struct {
+	name string
+	dir  string
+}
+
+// GetMozillaFiles returns places.sqlite of every profile found by profile
+// name. Profiles with the same name in different browsers get the browser
+// name appended.
+func GetMozillaFiles() map[string]string {
+	files := make(map[string]string, 0)
+
+	user, _ := user.Current()
+	seen := make(map[string]bool)
+	for _, root := range mozillaProfileRoots {
+		baseDir := filepath.Join(user.HomeDir, root.path)
+		if _, err := os.Stat(baseDir); err != nil {
+			continue
+		}
+
+		for _, profile := range readMozillaProfiles(baseDir) {
+			placesFile := filepath.Join(profile.dir, "places.sqlite")
+			if _, err := os.Stat(placesFile); err != nil {
+				continue
+			}
+			// Snap and Flatpak installs may be symlinked to each other
+			resolved, err := filepath.EvalSymlinks(placesFile)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if seen[resolved] {
+				continue
+			}
+			seen[resolved] = true
+
+			name := profile.name
+			if _, taken := files[name]; taken {
+				name = fmt.Sprintf("%s (%s)", profile.name, root.label)
+			}
+			for i := 2; ; i++ {
+				if _, taken := files[name]; !taken {
+					break
+				}
+				name = fmt.Sprintf("%s (%s %d)", profile.name, root.label, i)
+			}
+			log.Println(name, placesFile)
+			files[name] = placesFile
+		}
+	}
+
+	return files
+}
+
+// readMozillaProfiles lists profiles from profiles.ini and installs.ini in
+// baseDir, falling back to any directory with places.sqlite in it
+func readMozillaProfiles(baseDir string) []mozillaProfile {
+	profiles := make([]mozillaProfile, 0)
+	known := make(map[string]bool)
+	add := func(name string, dir string) {
+		dir = filepath.Clean(dir)
+		if known[dir] {
+			return
+		}
+		known[dir] = true
+		if name == "" {
+			name = filepath.Base(dir)
+		}
+		profiles = append(profiles, mozillaProfile{name: name, dir: dir})
+	}
+	profileDir := func(path string, isRelative bool) string {
+		if isRelative || !filepath.IsAbs(path) {
+			return filepath.Join(baseDir, filepath.FromSlash(path))
+		}
+		return path
+	}
+
+	if cfg, err := ini.Load(filepath.Join(baseDir, "profiles.ini")); err == nil {
+		for _, section := range cfg.Sections() {
+			path := section.Key("Path").String()
+			// Install sections have no path
+			if path == "" {
+				continue
+			}
+			add(section.Key("Name").String(),
+				profileDir(path, section.Key("IsRelative").MustBool(true)))
+		}
+	} else if !os.IsNotExist(err) {
+		log.Println(err)
+	}
+
+	// Default profiles of installs are normally listed in profiles.ini too
+	if cfg, err := ini.Load(filepath.Join(baseDir, "installs.ini")); err == nil {
+		for _, section := range cfg.Sections() {
+			if path := section.Key("Default").String(); path != "" {
+				add("", profileDir(path, false))
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		log.Println(err)
+	}
+
+	if len(profiles) > 0 {
+		return profiles
+	}
+
+	for _, dir := range []string{baseDir, filepath.Join(baseDir, "Profiles")} {
+		items, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, item := range items {
+			path := filepath.Join(dir, item.Name())
+			if _, err := os.Stat(filepath.Join(path, "places.sqlite")); item.IsDir() && err == nil {
+				add("", path)
+			}
+		}
+	}
+	return profiles
+}
@@ -0,0 +1,187 @@
+package implementation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+Open and recently closed Firefox tabs, read from the session file which
+Firefox rewrites every few seconds while running
+*/
+var (
+	mozillaSessionRelPath = "sessionstore-backups/recovery.jsonlz4"
+
+	// Pages not worth a note
+	sessionIgnoredURLs = map[string]bool{
+		"about:blank":           true,
+		"about:home":            true,
+		"about:newtab":          true,
+		"about:privatebrowsing": true,
+	}
+)
+
+const (
+	sessionClosedTag = "Closed"
+)
+
+type MozillaSessionImplementation struct {
+	path     string
+	favicons *mozillaFavicons
+}
+
+type sessionEntry struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+type sessionTab struct {
+	Entries      []sessionEntry `json:"entries"`
+	Index        int            `json:"index"`
+	LastAccessed int64          `json:"lastAccessed"`
+}
+
+type sessionClosedTab struct {
+	State    sessionTab `json:"state"`
+	ClosedAt int64      `json:"closedAt"`
+}
+
+type sessionWindow struct {
+	Tabs       []sessionTab       `json:"tabs"`
+	ClosedTabs []sessionClosedTab `json:"_closedTabs"`
+	ClosedAt   int64              `json:"closedAt"`
+}
+
+type sessionState struct {
+	Windows       []sessionWindow `json:"windows"`
+	ClosedWindows []sessionWindow `json:"_closedWindows"`
+}
+
+func NewMozillaSessionImplementation(config map[string]string) *MozillaSessionImplementation {
+	// The session file is in sessionstore-backups of the profile
+	profileDir := filepath.Dir(filepath.Dir(config["path"]))
+	return &MozillaSessionImplementation{path: config["path"],
+		favicons: newMozillaFavicons(filepath.Join(profileDir, faviconsFileName))}
+}
+
+func (self *MozillaSessionImplementation) CanWrite() (bool, error) {
+	return false, errors.New("Tabs can only be opened in Firefox")
+}
+
+func (self *MozillaSessionImplementation) SupportedProperties() map[string]types.Writable {
+	return map[string]types.Writable{"Title": false, "URI": false}
+}
+
+func (self *MozillaSessionImplementation) LoadData() (map[uint64]*types.Note, error) {
+	data, err := readMozLz4(self.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state sessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %w", self.path, err)
+	}
+
+	notes := make(map[uint64]*types.Note)
+	// The same page may be open several times
+	seen := make(map[string]int)
+	add := func(tab sessionTab, tags []string, at int64) {
+		if len(tab.Entries) == 0 {
+			return
+		}
+		// Index is 1-based and points to the current history entry
+		index := min(max(tab.Index, 1), len(tab.Entries)) - 1
+		entry := tab.Entries[index]
+		if entry.URL == "" || sessionIgnoredURLs[entry.URL] {
+			return
+		}
+
+		key := strings.Join(tags, "/") + "\x00" + entry.URL
+		uuid := stringUUID(key, strconv.Itoa(seen[key]))
+		seen[key]++
+
+		title := entry.Title
+		if title == "" {
+			title = entry.URL
+		}
+		note := types.NewNote(uuid, title)
+		note.SetFlag(types.FlagReadOnly)
+		note.URI = entry.URL
+		note.Type = types.NoteTypeBookmark
+		note.Tags = slices.Clone(tags)
+		note.ModifiedAt = sessionTime(at)
+		notes[uuid] = note
+	}
+
+	addWindow := func(window sessionWindow, tag string) {
+		var tags []string
+		if window.ClosedAt != 0 {
+			tags = []string{sessionClosedTag, tag}
+		} else {
+			tags = []string{tag}
+		}
+		for _, tab := range window.Tabs {
+			add(tab, tags, tab.LastAccessed)
+		}
+		for _, tab := range window.ClosedTabs {
+			add(tab.State, []string{sessionClosedTag, tag}, tab.ClosedAt)
+		}
+	}
+
+	for i, window := range state.Windows {
+		addWindow(window, fmt.Sprintf("Window %d", i+1))
+	}
+	for i, window := range state.ClosedWindows {
+		addWindow(window, fmt.Sprintf("Closed window %d", i+1))
+	}
+
+	return notes, nil
+}
+
+// Session timestamps are in milliseconds
+func sessionTime(milliseconds int64) time.Time {
+	if milliseconds == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(milliseconds)
+}
+
+// Watch reports a reload whenever Firefox saves the session
+func (self *MozillaSessionImplementation) Watch(changes chan<- *types.ChangeSet, stop <-chan struct{}) error {
+	return watchModTime(self.path, changes, stop)
+}
+
+func (self *MozillaSessionImplementation) PutData(note *types.Note) error {
+	return errors.New("Opening tabs is not supported")
+}
+
+func (self *MozillaSessionImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	return errors.New("Editing tabs is not supported")
+}
+
+func (self *MozillaSessionImplementation) DeleteData(note *types.Note) error {
+	return errors.New("Closing tabs is not supported")
+}
+
+// GetMozillaSessionFiles returns session files of the profiles found by
+// GetMozillaFiles
+func GetMozillaSessionFiles() map[string]string {
+	files := make(map[string]string)
+	for name, placesFile := range GetMozillaFiles() {
+		sessionFile := filepath.Join(filepath.Dir(placesFile), mozillaSessionRelPath)
+		if _, err := os.Stat(sessionFile); err == nil {
+			files[name] = sessionFile
+		}
+	}
+	return files
+}
@@ -0,0 +1,115 @@
+package implementation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+/*
+Firefox compresses session files with a single LZ4 block prefixed by a magic
+string and the decompressed size, which no standard LZ4 tool understands:
+https://searchfox.org/mozilla-central/source/toolkit/components/lz4/lz4.js
+*/
+var mozLz4Magic = []byte("mozLz40\x00")
+
+var errLz4Corrupt = errors.New("corrupt LZ4 block")
+
+func readMozLz4(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(mozLz4Magic)+4 || !bytes.Equal(data[:len(mozLz4Magic)], mozLz4Magic) {
+		return nil, fmt.Errorf("%s: not a mozLz4 file", path)
+	}
+
+	size := binary.LittleEndian.Uint32(data[len(mozLz4Magic):])
+	ret, err := lz4Block(data[len(mozLz4Magic)+4:], int(size))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ret, nil
+}
+
+// A byte of LZ4 input expands to 255 bytes at most, larger sizes come from
+// corrupt headers
+const lz4MaxRatio = 255
+
+// lz4Block decompresses a raw LZ4 block of known decompressed size
+func lz4Block(src []byte, size int) ([]byte, error) {
+	if size < 0 || size > len(src)*lz4MaxRatio {
+		return nil, errLz4Corrupt
+	}
+	dst := make([]byte, 0, size)
+
+	// Lengths of 15 and over continue in the following bytes
+	readLength := func(i int, length int) (int, int, error) {
+		if length != 15 {
+			return i, length, nil
+		}
+		for {
+			if i >= len(src) {
+				return i, 0, errLz4Corrupt
+			}
+			b := src[i]
+			i++
+			length += int(b)
+			if b != 255 {
+				return i, length, nil
+			}
+		}
+	}
+
+	for i := 0; i < len(src); {
+		var literals, length int
+		var err error
+		token := src[i]
+		i++
+
+		i, literals, err = readLength(i, int(token>>4))
+		if err != nil {
+			return nil, err
+		}
+		if i+literals > len(src) || len(dst)+literals > size {
+			return nil, errLz4Corrupt
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+
+		// The last sequence has literals only
+		if i == len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, errLz4Corrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, errLz4Corrupt
+		}
+
+		i, length, err = readLength(i, int(token&15))
+		if err != nil {
+			return nil, err
+		}
+		length += 4
+		if len(dst)+length > size {
+			return nil, errLz4Corrupt
+		}
+		// Matches may overlap the bytes being written
+		start := len(dst) - offset
+		for k := 0; k < length; k++ {
+			dst = append(dst, dst[start+k])
+		}
+	}
+
+	if len(dst) != size {
+		return nil, errLz4Corrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,89 @@
+package implementation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLz4Block(t *testing.T) {
+	tests := []struct {
+		name string
+		src  []byte
+		want string
+	}{
+		{"literals only", []byte("\x50hello"), "hello"},
+		// "abc", then 9 bytes copied from 3 back, overlapping the output
+		{"overlapping match", []byte("\x35abc\x03\x00\x20xy"), "abcabcabcabcxy"},
+		// 15 + 5 literals
+		{"long literals", append([]byte("\xf0\x05"), "abcdefghijklmnopqrst"...),
+			"abcdefghijklmnopqrst"},
+		// 4 + 15 + 255 + 1 bytes repeated
+		{"long match", []byte("\x1fa\x01\x00\xff\x01"), string(bytes.Repeat([]byte("a"), 276))},
+	}
+
+	for _, test := range tests {
+		got, err := lz4Block(test.src, len(test.want))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLz4BlockCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		src  []byte
+		size int
+	}{
+		{"truncated literals", []byte("\x50hel"), 5},
+		{"truncated offset", []byte("\x35abc\x03"), 12},
+		{"truncated length", []byte("\xf0"), 20},
+		{"offset before start", []byte("\x35abc\x04\x00"), 12},
+		{"zero offset", []byte("\x35abc\x00\x00"), 12},
+		{"longer than size", []byte("\x50hello"), 4},
+		{"shorter than size", []byte("\x50hello"), 6},
+		{"size out of proportion", []byte("\x50hello"), 1 << 31},
+		{"negative size", []byte("\x50hello"), -1},
+	}
+
+	for _, test := range tests {
+		if got, err := lz4Block(test.src, test.size); !errors.Is(err, errLz4Corrupt) {
+			t.Errorf("%s: got %q, %v, want a corrupt block error", test.name, got, err)
+		}
+	}
+}
+
+func TestReadMozLz4(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recovery.jsonlz4")
+	data := append([]byte{}, mozLz4Magic...)
+	data = binary.LittleEndian.AppendUint32(data, 2)
+	data = append(data, "\x20{}"...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readMozLz4(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "{}" {
+		t.Errorf("got %q, want {}", got)
+	}
+
+	// A corrupt header must not make for a huge allocation
+	binary.LittleEndian.PutUint32(data[len(mozLz4Magic):], 0xffffffff)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readMozLz4(path); !errors.Is(err, errLz4Corrupt) {
+		t.Errorf("got %v, want a corrupt block error", err)
+	}
+}
@@ -0,0 +1,446 @@
+package implementation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+The bookmarks.html format every browser imports and exports. It is HTML of
+the nineties: folders are H3 headers followed by DL lists, bookmarks are
+links, each optionally followed by a DD description. Unknown attributes such
+as ICON are kept as they are.
+*/
+const (
+	netscapeHeader = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+`
+	netscapeDefaultTitle = "Bookmarks"
+)
+
+type NetscapeImplementation struct {
+	path string
+	mx   sync.Mutex
+}
+
+type netscapeItem struct {
+	folder      bool
+	title       string
+	attrs       []html.Attribute
+	description string
+	children    []*netscapeItem
+}
+
+type netscapeDocument struct {
+	title   string
+	heading string
+	root    *netscapeItem
+}
+
+func NewNetscapeImplementation(config map[string]string) *NetscapeImplementation {
+	return &NetscapeImplementation{path: config["path"]}
+}
+
+func (self *NetscapeImplementation) CanWrite() (bool, error) {
+	file, err := os.CreateTemp(filepath.Dir(self.path), tempFilePattern)
+	if err != nil {
+		return false, err
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	return true, nil
+}
+
+func (self *NetscapeImplementation) SupportedProperties() map[string]types.Writable {
+	return map[string]types.Writable{"Title": true, "URI": true, "Body": true, "Tags": true}
+}
+
+func (self *NetscapeImplementation) LoadData() (map[uint64]*types.Note, error) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	doc, err := self.load()
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[uint64]*types.Note)
+	doc.walk(func(item *netscapeItem, parent *netscapeItem, paths []string, uuid uint64) {
+		note := types.NewNote(uuid, item.title)
+		note.Set("Body", item.description, true)
+		note.URI = item.attr("href")
+		note.Type = types.NoteTypeBookmark
+		note.Tags = slices.Clone(paths)
+		note.CreatedAt = item.time("add_date")
+		note.ModifiedAt = item.time("last_modified")
+		if note.ModifiedAt.IsZero() {
+			note.ModifiedAt = note.CreatedAt
+		}
+		data[uuid] = note
+	})
+	return data, nil
+}
+
+// Watch reports a reload whenever the file is rewritten
+func (self *NetscapeImplementation) Watch(changes chan<- *types.ChangeSet, stop <-chan struct{}) error {
+	return watchModTime(self.path, changes, stop)
+}
+
+func (self *NetscapeImplementation) PutData(note *types.Note) error {
+	if note.URI == "" {
+		return errors.New("bookmark must have an URI")
+	}
+
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	doc, err := self.load()
+	if err != nil {
+		return err
+	}
+
+	// The file keeps whole seconds only
+	now := time.Now().Truncate(time.Second)
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = now
+	}
+	note.ModifiedAt = now
+	item := &netscapeItem{title: note.Title, description: note.Body}
+	item.setAttr("href", note.URI)
+	item.setTime("add_date", note.CreatedAt)
+	item.setTime("last_modified", note.ModifiedAt)
+	folder := doc.folder(note.Tags)
+	folder.children = append(folder.children, item)
+
+	if err := self.save(doc); err != nil {
+		return err
+	}
+	note.UUID = doc.uuid(item)
+	return nil
+}
+
+// UpdateData moves the bookmark to the folder named by the tags. It refuses
+// to touch bookmarks modified by someone else since the note was loaded.
+func (self *NetscapeImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	if newNote.URI == "" {
+		return errors.New("bookmark must have an URI")
+	}
+
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	doc, err := self.load()
+	if err != nil {
+		return err
+	}
+	item, parent, paths := doc.find(oldNote.UUID)
+	if item == nil {
+		return fmt.Errorf("%w: \"%s\" is not in the file anymore", ErrConflict, oldNote.Title)
+	}
+	if modifiedAt := item.time("last_modified"); !modifiedAt.IsZero() &&
+		!oldNote.ModifiedAt.IsZero() && !modifiedAt.Equal(oldNote.ModifiedAt) {
+		return fmt.Errorf("%w: \"%s\" was modified in the file", ErrConflict, oldNote.Title)
+	}
+
+	item.title = newNote.Title
+	item.description = newNote.Body
+	item.setAttr("href", newNote.URI)
+	newNote.ModifiedAt = time.Now().Truncate(time.Second)
+	item.setTime("last_modified", newNote.ModifiedAt)
+
+	if !slices.Equal(paths, newNote.Tags) {
+		parent.children = slices.DeleteFunc(parent.children, func(child *netscapeItem) bool {
+			return child == item
+		})
+		folder := doc.folder(newNote.Tags)
+		folder.children = append(folder.children, item)
+	}
+
+	if err := self.save(doc); err != nil {
+		return err
+	}
+	newNote.UUID = doc.uuid(item)
+	return nil
+}
+
+func (self *NetscapeImplementation) DeleteData(note *types.Note) error {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	doc, err := self.load()
+	if err != nil {
+		return err
+	}
+	item, parent, _ := doc.find(note.UUID)
+	if item == nil {
+		return nil
+	}
+	parent.children = slices.DeleteFunc(parent.children, func(child *netscapeItem) bool {
+		return child == item
+	})
+
+	return self.save(doc)
+}
+
+// load parses the file, a missing file is an empty document
+func (self *NetscapeImplementation) load() (*netscapeDocument, error) {
+	file, err := os.Open(self.path)
+	if os.IsNotExist(err) {
+		return &netscapeDocument{title: netscapeDefaultTitle,
+			heading: netscapeDefaultTitle, root: &netscapeItem{folder: true}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	doc, err := parseNetscape(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", self.path, err)
+	}
+	return doc, nil
+}
+
+func (self *NetscapeImplementation) save(doc *netscapeDocument) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(self.path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return writeFileAtomic(self.path, doc.render(), perm)
+}
+
+func parseNetscape(reader io.Reader) (*netscapeDocument, error) {
+	doc := &netscapeDocument{title: netscapeDefaultTitle, heading: netscapeDefaultTitle,
+		root: &netscapeItem{folder: true}}
+	stack := make([]*netscapeItem, 0)
+	current := func() *netscapeItem {
+		if len(stack) == 0 {
+			return doc.root
+		}
+		return stack[len(stack)-1]
+	}
+
+	var text *string
+	var textTag string
+	var last, pendingFolder *netscapeItem
+	tokenizer := html.NewTokenizer(reader)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return doc, nil
+			}
+			return nil, tokenizer.Err()
+		case html.TextToken:
+			if text != nil {
+				*text += string(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if token.Data == textTag {
+				*text = strings.TrimSpace(*text)
+				text, textTag = nil, ""
+			}
+			if token.Data == "dl" && len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case html.StartTagToken:
+			token := tokenizer.Token()
+			// Descriptions have no closing tag
+			if textTag == "dd" {
+				*text = strings.TrimSpace(*text)
+				text, textTag = nil, ""
+			}
+			switch token.Data {
+			case "title":
+				doc.title = ""
+				text, textTag = &doc.title, "title"
+			case "h1":
+				doc.heading = ""
+				text, textTag = &doc.heading, "h1"
+			case "h3":
+				item := &netscapeItem{folder: true, attrs: token.Attr}
+				parent := current()
+				parent.children = append(parent.children, item)
+				text, textTag = &item.title, "h3"
+				last, pendingFolder = item, item
+			case "a":
+				item := &netscapeItem{attrs: token.Attr}
+				parent := current()
+				parent.children = append(parent.children, item)
+				text, textTag = &item.title, "a"
+				last = item
+			case "dd":
+				if last != nil {
+					text, textTag = &last.description, "dd"
+				}
+			case "dl":
+				// The first list is the root, others belong to the header before
+				if pendingFolder != nil {
+					stack = append(stack, pendingFolder)
+					pendingFolder = nil
+				} else {
+					stack = append(stack, current())
+				}
+			}
+		}
+	}
+}
+
+func (self *netscapeDocument) render() []byte {
+	var out strings.Builder
+	out.WriteString(netscapeHeader)
+	fmt.Fprintf(&out, "<TITLE>%s</TITLE>\n<H1>%s</H1>\n\n",
+		html.EscapeString(self.title), html.EscapeString(self.heading))
+	renderNetscapeList(&out, self.root, 0)
+	return []byte(out.String())
+}
+
+func renderNetscapeList(out *strings.Builder, folder *netscapeItem, depth int) {
+	indent := strings.Repeat("    ", depth)
+	out.WriteString(indent + "<DL><p>\n")
+	for _, item := range folder.children {
+		out.WriteString(indent + "    <DT>")
+		if item.folder {
+			fmt.Fprintf(out, "<H3%s>%s</H3>\n", renderNetscapeAttrs(item.attrs),
+				html.EscapeString(item.title))
+		} else {
+			fmt.Fprintf(out, "<A%s>%s</A>\n", renderNetscapeAttrs(item.attrs),
+				html.EscapeString(item.title))
+		}
+		if item.description != "" {
+			fmt.Fprintf(out, "%s    <DD>%s\n", indent, html.EscapeString(item.description))
+		}
+		if item.folder {
+			renderNetscapeList(out, item, depth+1)
+		}
+	}
+	out.WriteString(indent + "</DL><p>\n")
+}
+
+func renderNetscapeAttrs(attrs []html.Attribute) string {
+	var out strings.Builder
+	for _, attr := range attrs {
+		fmt.Fprintf(&out, ` %s="%s"`, strings.ToUpper(attr.Key), html.EscapeString(attr.Val))
+	}
+	return out.String()
+}
+
+// walk calls fn for every bookmark with its folder path and UUID. Bookmarks
+// have no ids, so the UUID comes from the time it was added and the order of
+// bookmarks added at the same time, which survive editing the URI. Bookmarks
+// without a time fall back to the URI.
+func (self *netscapeDocument) walk(fn func(item *netscapeItem, parent *netscapeItem,
+	paths []string, uuid uint64)) {
+	seen := make(map[string]int)
+	var walkFolder func(folder *netscapeItem, paths []string)
+	walkFolder = func(folder *netscapeItem, paths []string) {
+		for _, item := range folder.children {
+			if item.folder {
+				walkFolder(item, append(slices.Clone(paths), item.title))
+				continue
+			}
+			key := "add_date\x00" + item.attr("add_date")
+			if item.attr("add_date") == "" {
+				key = "href\x00" + item.attr("href")
+			}
+			uuid := stringUUID(key, strconv.Itoa(seen[key]))
+			seen[key]++
+			fn(item, folder, paths, uuid)
+		}
+	}
+	walkFolder(self.root, nil)
+}
+
+func (self *netscapeDocument) find(uuid uint64) (*netscapeItem, *netscapeItem, []string) {
+	var found, foundParent *netscapeItem
+	var foundPaths []string
+	self.walk(func(item *netscapeItem, parent *netscapeItem, paths []string, itemUUID uint64) {
+		if found == nil && itemUUID == uuid {
+			found, foundParent, foundPaths = item, parent, paths
+		}
+	})
+	return found, foundParent, foundPaths
+}
+
+func (self *netscapeDocument) uuid(item *netscapeItem) uint64 {
+	var ret uint64
+	self.walk(func(other *netscapeItem, parent *netscapeItem, paths []string, uuid uint64) {
+		if other == item {
+			ret = uuid
+		}
+	})
+	return ret
+}
+
+// folder returns the folder with the path, creating missing ones
+func (self *netscapeDocument) folder(paths []string) *netscapeItem {
+	folder := self.root
+	for _, title := range paths {
+		var next *netscapeItem
+		for _, child := range folder.children {
+			if child.folder && child.title == title {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			next = &netscapeItem{folder: true, title: title}
+			now := time.Now()
+			next.setTime("add_date", now)
+			next.setTime("last_modified", now)
+			folder.children = append(folder.children, next)
+		}
+		folder = next
+	}
+	return folder
+}
+
+func (self *netscapeItem) attr(key string) string {
+	for _, attr := range self.attrs {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func (self *netscapeItem) setAttr(key string, value string) {
+	for i := range self.attrs {
+		if self.attrs[i].Key == key {
+			self.attrs[i].Val = value
+			return
+		}
+	}
+	self.attrs = append(self.attrs, html.Attribute{Key: key, Val: value})
+}
+
+// Times are in seconds since the epoch
+func (self *netscapeItem) time(key string) time.Time {
+	seconds, err := strconv.ParseInt(self.attr(key), 10, 64)
+	if err != nil || seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+func (self *netscapeItem) setTime(key string, value time.Time) {
+	self.setAttr(key, strconv.FormatInt(value.Unix(), 10))
+}
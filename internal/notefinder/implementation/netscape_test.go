@@ -0,0 +1,150 @@
+package implementation
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+
+	"notefinder/internal/notefinder/types"
+)
+
+const netscapeFixture = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks Menu</H1>
+<DL><p>
+    <DT><A HREF="https://example.com/" ADD_DATE="1700000000" LAST_MODIFIED="1700000100" ICON="data:image/png;base64,AAAA">Example</A>
+    <DD>Top level &amp; described
+    <DT><H3 ADD_DATE="1600000000" PERSONAL_TOOLBAR_FOLDER="true">Toolbar</H3>
+    <DL><p>
+        <DT><A HREF="https://go.dev/" ADD_DATE="1700000200" TAGS="golang">Go</A>
+        <DT><H3 ADD_DATE="1600000001">Docs</H3>
+        <DD>Folder description
+        <DL><p>
+            <DT><A HREF="https://pkg.go.dev/" ADD_DATE="1700000300" SHORTCUTURL="pkg">Packages</A>
+            <DD>Multi word
+description
+        </DL><p>
+    </DL><p>
+    <DT><A HREF="https://example.org/">No date</A>
+</DL><p>
+`
+
+type netscapeBookmark struct {
+	title, description string
+	paths              []string
+	attrs              []html.Attribute
+}
+
+func netscapeBookmarks(doc *netscapeDocument) []netscapeBookmark {
+	ret := make([]netscapeBookmark, 0)
+	doc.walk(func(item *netscapeItem, parent *netscapeItem, paths []string, uuid uint64) {
+		ret = append(ret, netscapeBookmark{title: item.title, description: item.description,
+			paths: paths, attrs: item.attrs})
+	})
+	return ret
+}
+
+func TestNetscapeRoundTrip(t *testing.T) {
+	doc, err := parseNetscape(strings.NewReader(netscapeFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netscapeBookmark{
+		{title: "Example", description: "Top level & described", attrs: []html.Attribute{
+			{Key: "href", Val: "https://example.com/"}, {Key: "add_date", Val: "1700000000"},
+			{Key: "last_modified", Val: "1700000100"}, {Key: "icon", Val: "data:image/png;base64,AAAA"}}},
+		{title: "Go", paths: []string{"Toolbar"}, attrs: []html.Attribute{
+			{Key: "href", Val: "https://go.dev/"}, {Key: "add_date", Val: "1700000200"},
+			{Key: "tags", Val: "golang"}}},
+		{title: "Packages", description: "Multi word\ndescription", paths: []string{"Toolbar", "Docs"},
+			attrs: []html.Attribute{{Key: "href", Val: "https://pkg.go.dev/"},
+				{Key: "add_date", Val: "1700000300"}, {Key: "shortcuturl", Val: "pkg"}}},
+		{title: "No date", attrs: []html.Attribute{{Key: "href", Val: "https://example.org/"}}},
+	}
+	assertNetscapeBookmarks(t, netscapeBookmarks(doc), want)
+
+	rendered := doc.render()
+	again, err := parseNetscape(bytes.NewReader(rendered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNetscapeBookmarks(t, netscapeBookmarks(again), want)
+
+	if again.heading != "Bookmarks Menu" {
+		t.Errorf("heading = %q, want Bookmarks Menu", again.heading)
+	}
+	toolbar := again.root.children[1]
+	if toolbar.attr("personal_toolbar_folder") != "true" {
+		t.Errorf("toolbar attributes = %v", toolbar.attrs)
+	}
+	if docs := toolbar.children[1]; docs.description != "Folder description" {
+		t.Errorf("folder description = %q", docs.description)
+	}
+	if !bytes.Equal(again.render(), rendered) {
+		t.Errorf("second render differs:\n%s\nfirst:\n%s", again.render(), rendered)
+	}
+}
+
+func assertNetscapeBookmarks(t *testing.T, got []netscapeBookmark, want []netscapeBookmark) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d bookmarks, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].title != want[i].title || got[i].description != want[i].description ||
+			!slices.Equal(got[i].paths, want[i].paths) || !slices.Equal(got[i].attrs, want[i].attrs) {
+			t.Errorf("bookmark %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNetscapeUpdateKeepsUUID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bookmarks.html")
+	if err := os.WriteFile(path, []byte(netscapeFixture), 0644); err != nil {
+		t.Fatal(err)
+	}
+	impl := NewNetscapeImplementation(map[string]string{"path": path})
+
+	data, err := impl.LoadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var oldNote *types.Note
+	for _, note := range data {
+		if note.Title == "Go" {
+			oldNote = note
+		}
+	}
+	if oldNote == nil {
+		t.Fatalf("bookmark not loaded: %v", data)
+	}
+
+	newNote := oldNote.Clone()
+	newNote.URI = "https://go.dev/doc/"
+	newNote.Tags = []string{"Reading"}
+	if err := impl.UpdateData(oldNote, newNote); err != nil {
+		t.Fatal(err)
+	}
+	if newNote.UUID != oldNote.UUID {
+		t.Errorf("UUID changed from %d to %d", oldNote.UUID, newNote.UUID)
+	}
+
+	data, err = impl.LoadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, ok := data[oldNote.UUID]
+	if !ok {
+		t.Fatalf("bookmark %d not found after reload", oldNote.UUID)
+	}
+	if reloaded.URI != newNote.URI || !slices.Equal(reloaded.Tags, newNote.Tags) {
+		t.Errorf("reloaded %q %v, want %q %v", reloaded.URI, reloaded.Tags,
+			newNote.URI, newNote.Tags)
+	}
+}
@@ -0,0 +1,669 @@
+package implementation
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+Org-mode files, one note per top-level headline or, with mode = file in the
+config, one note per file. Writes replace only the lines of the headline
+being edited.
+*/
+const (
+	orgExt = ".org"
+
+	orgStateProperty     = "State"
+	orgScheduledProperty = "Scheduled"
+	orgDeadlineProperty  = "Deadline"
+)
+
+var (
+	orgTodoKeywordsRe = regexp.MustCompile(`^#\+(?:SEQ_|TYP_)?TODO:\s*(.*)$`)
+	orgTitleRe        = regexp.MustCompile(`^#\+(?i:title):\s*(.*)$`)
+	orgFileTagsRe     = regexp.MustCompile(`^#\+(?i:filetags):\s*(.*)$`)
+	orgTagsRe         = regexp.MustCompile(`\s+(:[^\s:]+(?::[^\s:]+)*:)\s*$`)
+	orgPriorityRe     = regexp.MustCompile(`^\[#([A-Z0-9])\]\s*`)
+	orgPlanningRe     = regexp.MustCompile(`(SCHEDULED|DEADLINE|CLOSED):\s*([<\[][^>\]]*[>\]])`)
+	orgPropertyRe     = regexp.MustCompile(`^\s*:([^\s:]+):\s*(.*?)\s*$`)
+	orgTimestampRe    = regexp.MustCompile(`(\d{4}-\d{2}-\d{2})(?:\s+[^\s\d>\]]+)?(?:\s+(\d{1,2}:\d{2}))?`)
+)
+
+type OrgImplementation struct {
+	path    string
+	perFile bool
+	mx      sync.Mutex
+}
+
+type orgKeywords struct {
+	todo, done []string
+}
+
+// orgHeadline is a top-level headline with everything up to the next one,
+// line numbers are absolute
+type orgHeadline struct {
+	start, end int
+	keyword    string
+	priority   string
+	title      string
+	tags       []string
+	planning   map[string]string
+	// Line numbers of the planning line and the drawer, -1 if missing
+	planningLine           int
+	drawerStart, drawerEnd int
+	properties             [][2]string
+	bodyStart              int
+}
+
+func NewOrgImplementation(config map[string]string) *OrgImplementation {
+	return &OrgImplementation{path: config["path"], perFile: config["mode"] == "file"}
+}
+
+func (self *OrgImplementation) CanWrite() (bool, error) {
+	dir := self.path
+	if info, err := os.Stat(self.path); err == nil && !info.IsDir() {
+		dir = filepath.Dir(self.path)
+	}
+	file, err := os.CreateTemp(dir, tempFilePattern)
+	if err != nil {
+		return false, err
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	return true, nil
+}
+
+func (self *OrgImplementation) SupportedProperties() map[string]types.Writable {
+	if self.perFile {
+		return map[string]types.Writable{"Body": true}
+	}
+	return map[string]types.Writable{"Title": true, "Body": true, "Tags": true,
+		types.PropertyCompleted: true, types.PropertyPriority: true}
+}
+
+func (self *OrgImplementation) LoadData() (map[uint64]*types.Note, error) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	files, err := self.files()
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[uint64]*types.Note)
+	for _, path := range files {
+		notes, err := self.readFile(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, note := range notes {
+			data[note.UUID] = note
+		}
+	}
+	return data, nil
+}
+
+func (self *OrgImplementation) files() ([]string, error) {
+	info, err := os.Stat(self.path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{self.path}, nil
+	}
+
+	files := make([]string, 0)
+	err = filepath.WalkDir(self.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == self.path {
+				return err
+			}
+			log.Println(err)
+			return nil
+		}
+		if d.IsDir() && path != self.path && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && filepath.Ext(path) == orgExt && !strings.HasPrefix(d.Name(), ".#") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (self *OrgImplementation) readFile(path string) ([]*types.Note, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := readOrgLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if self.perFile {
+		note := types.NewNote(stringUUID(path), strings.TrimSuffix(filepath.Base(path), orgExt))
+		note.Set("Body", strings.Join(lines, "\n"), true)
+		for _, line := range lines {
+			if match := orgTitleRe.FindStringSubmatch(line); match != nil && match[1] != "" {
+				note.Title = match[1]
+			}
+			if match := orgFileTagsRe.FindStringSubmatch(line); match != nil {
+				note.Tags = append(note.Tags, splitOrgTags(match[1])...)
+			}
+		}
+		note.CreatedAt = birthTime(path, info)
+		note.ModifiedAt = info.ModTime()
+		note.AdditionalProperties = map[string]string{pathProperty: path}
+		return []*types.Note{note}, nil
+	}
+
+	keywords := parseOrgKeywords(lines)
+	notes := make([]*types.Note, 0)
+	for uuid, headline := range parseOrgHeadlines(path, lines, keywords) {
+		note := headline.note(lines, keywords)
+		note.UUID = uuid
+		if note.CreatedAt.IsZero() {
+			note.CreatedAt = birthTime(path, info)
+		}
+		note.ModifiedAt = info.ModTime()
+		note.AdditionalProperties[pathProperty] = path
+		notes = append(notes, note)
+	}
+	return notes, nil
+}
+
+func readOrgLines(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	return strings.Split(text, "\n"), nil
+}
+
+func writeOrgLines(path string, lines []string) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), perm)
+}
+
+func parseOrgKeywords(lines []string) orgKeywords {
+	keywords := orgKeywords{}
+	for _, line := range lines {
+		match := orgTodoKeywordsRe.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		todo, done, found := strings.Cut(match[1], "|")
+		todoWords := orgKeywordNames(todo)
+		if !found && len(todoWords) > 0 {
+			// Without a bar the last keyword means done
+			todo, done = "", todoWords[len(todoWords)-1]
+			todoWords = todoWords[:len(todoWords)-1]
+		}
+		keywords.todo = append(keywords.todo, todoWords...)
+		keywords.done = append(keywords.done, orgKeywordNames(done)...)
+	}
+	// Either side may be left empty, items still need a keyword to be
+	// reopened or completed with
+	if len(keywords.todo) == 0 {
+		keywords.todo = []string{"TODO"}
+	}
+	if len(keywords.done) == 0 {
+		keywords.done = []string{"DONE"}
+	}
+	return keywords
+}
+
+// orgKeywordNames drops fast access keys, e.g. TODO(t)
+func orgKeywordNames(in string) []string {
+	ret := make([]string, 0)
+	for _, word := range strings.Fields(in) {
+		if name, _, _ := strings.Cut(word, "("); name != "" {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+func splitOrgTags(in string) []string {
+	return strings.FieldsFunc(in, func(r rune) bool {
+		return r == ':' || r == ' '
+	})
+}
+
+func isOrgTopHeadline(line string) bool {
+	return strings.HasPrefix(line, "* ") || line == "*"
+}
+
+// parseOrgHeadlines returns top-level headlines by UUID. The UUID comes
+// from the ID property or, failing that, from the file and the title.
+func parseOrgHeadlines(path string, lines []string, keywords orgKeywords) map[uint64]*orgHeadline {
+	ret := make(map[uint64]*orgHeadline)
+	seen := make(map[string]int)
+	for i := 0; i < len(lines); i++ {
+		if !isOrgTopHeadline(lines[i]) {
+			continue
+		}
+		end := i + 1
+		for end < len(lines) && !isOrgTopHeadline(lines[end]) {
+			end++
+		}
+		headline := parseOrgHeadline(lines, i, end, keywords)
+
+		var uuid uint64
+		if id := headline.property("ID"); id != "" {
+			uuid = stringUUID(id)
+		} else {
+			uuid = stringUUID(path, headline.title, strconv.Itoa(seen[headline.title]))
+			seen[headline.title]++
+		}
+		ret[uuid] = headline
+		i = end - 1
+	}
+	return ret
+}
+
+func parseOrgHeadline(lines []string, start int, end int, keywords orgKeywords) *orgHeadline {
+	headline := &orgHeadline{start: start, end: end, planning: make(map[string]string),
+		planningLine: -1, drawerStart: -1, drawerEnd: -1}
+
+	text := strings.TrimSpace(strings.TrimLeft(lines[start], "*"))
+	if word, rest, _ := strings.Cut(text, " "); slices.Contains(keywords.todo, word) ||
+		slices.Contains(keywords.done, word) {
+		headline.keyword = word
+		text = strings.TrimSpace(rest)
+	}
+	if match := orgPriorityRe.FindStringSubmatch(text); match != nil {
+		headline.priority = match[1]
+		text = text[len(match[0]):]
+	}
+	if match := orgTagsRe.FindStringSubmatchIndex(text); match != nil {
+		headline.tags = splitOrgTags(text[match[2]:match[3]])
+		text = text[:match[0]]
+	}
+	headline.title = strings.TrimSpace(text)
+
+	next := start + 1
+	if next < end && orgPlanningRe.MatchString(lines[next]) &&
+		strings.TrimSpace(orgPlanningRe.ReplaceAllString(lines[next], "")) == "" {
+		headline.planningLine = next
+		for _, match := range orgPlanningRe.FindAllStringSubmatch(lines[next], -1) {
+			headline.planning[match[1]] = match[2]
+		}
+		next++
+	}
+	if next < end && strings.EqualFold(strings.TrimSpace(lines[next]), ":PROPERTIES:") {
+		for i := next + 1; i < end; i++ {
+			if strings.EqualFold(strings.TrimSpace(lines[i]), ":END:") {
+				headline.drawerStart, headline.drawerEnd = next, i
+				break
+			}
+		}
+		if headline.drawerStart >= 0 {
+			for i := headline.drawerStart + 1; i < headline.drawerEnd; i++ {
+				if match := orgPropertyRe.FindStringSubmatch(lines[i]); match != nil {
+					headline.properties = append(headline.properties, [2]string{match[1], match[2]})
+				}
+			}
+			next = headline.drawerEnd + 1
+		}
+	}
+	headline.bodyStart = next
+
+	return headline
+}
+
+func (self *orgHeadline) property(key string) string {
+	for _, property := range self.properties {
+		if strings.EqualFold(property[0], key) {
+			return property[1]
+		}
+	}
+	return ""
+}
+
+func (self *orgHeadline) note(lines []string, keywords orgKeywords) *types.Note {
+	note := types.NewNote(0, self.title)
+	note.Set("Body", strings.Trim(strings.Join(lines[self.bodyStart:self.end], "\n"), "\n"), true)
+	note.Tags = append(note.Tags, self.tags...)
+	note.AdditionalProperties = make(map[string]string)
+	for _, property := range self.properties {
+		note.AdditionalProperties[property[0]] = property[1]
+	}
+	if created := parseOrgTime(self.property("CREATED")); !created.IsZero() {
+		note.CreatedAt = created
+	}
+
+	if self.keyword != "" {
+		note.Type = types.NoteTypeTodoList
+		note.AdditionalProperties[orgStateProperty] = self.keyword
+	}
+	if self.priority != "" {
+		note.AdditionalProperties[types.PropertyPriority] = self.priority
+	}
+	done := slices.Contains(keywords.done, self.keyword)
+	if done {
+		note.AdditionalProperties[types.PropertyCompleted] = ""
+		if closed := parseOrgTime(self.planning["CLOSED"]); !closed.IsZero() {
+			note.AdditionalProperties[types.PropertyCompleted] = closed.Format(time.DateOnly)
+		}
+	}
+	for key, property := range map[string]string{"SCHEDULED": orgScheduledProperty,
+		"DEADLINE": orgDeadlineProperty} {
+		if timestamp, ok := self.planning[key]; ok {
+			note.AdditionalProperties[property] = strings.Trim(timestamp, "<>[]")
+			if !done {
+				note.SetFlag(types.FlagNotify)
+			}
+		}
+	}
+
+	return note
+}
+
+func parseOrgTime(timestamp string) time.Time {
+	match := orgTimestampRe.FindStringSubmatch(timestamp)
+	if match == nil {
+		return time.Time{}
+	}
+	value, layout := match[1], time.DateOnly
+	if match[2] != "" {
+		value, layout = value+" "+match[2], "2006-01-02 15:04"
+	}
+	ret, err := time.ParseInLocation(layout, value, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return ret
+}
+
+func formatOrgTime(at time.Time, active bool) string {
+	ret := at.Format("2006-01-02 Mon")
+	if active {
+		return "<" + ret + ">"
+	}
+	return "[" + ret + " " + at.Format("15:04") + "]"
+}
+
+func (self *OrgImplementation) PutData(note *types.Note) error {
+	if self.perFile {
+		return errors.New("creating files is not supported, create headlines instead")
+	}
+	files, err := self.files()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no org file to add the headline to")
+	}
+
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	// New headlines go to the end of the first file, like a capture would
+	path := files[0]
+	lines, err := readOrgLines(path)
+	if err != nil {
+		return err
+	}
+	keywords := parseOrgKeywords(lines)
+
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = time.Now()
+	}
+	headline := &orgHeadline{planning: make(map[string]string), planningLine: -1,
+		drawerStart: -1, drawerEnd: -1}
+	if note.Type == types.NoteTypeTodoList {
+		headline.keyword = keywords.todo[0]
+	}
+	newNote := note.Clone()
+	if newNote.AdditionalProperties == nil {
+		newNote.AdditionalProperties = make(map[string]string)
+	}
+	newNote.AdditionalProperties["CREATED"] = formatOrgTime(note.CreatedAt, false)
+	section := headline.render(nil, &types.Note{}, newNote, keywords)
+
+	start := len(lines)
+	lines = append(lines, section...)
+	if err := writeOrgLines(path, lines); err != nil {
+		return err
+	}
+	return self.reload(path, lines, start, note)
+}
+
+// UpdateData replaces the lines of the headline, everything else in the
+// file stays as it was
+func (self *OrgImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	path := oldNote.AdditionalProperties[pathProperty]
+	lines, err := readOrgLines(path)
+	if err != nil {
+		return err
+	}
+
+	if self.perFile {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !oldNote.ModifiedAt.IsZero() && !info.ModTime().Equal(oldNote.ModifiedAt) {
+			return fmt.Errorf("%w: \"%s\" was modified on disk", ErrConflict, oldNote.Title)
+		}
+		if err := writeOrgLines(path, strings.Split(newNote.Body, "\n")); err != nil {
+			return err
+		}
+		if info, err := os.Stat(path); err == nil {
+			newNote.ModifiedAt = info.ModTime()
+		}
+		return nil
+	}
+
+	keywords := parseOrgKeywords(lines)
+	headline, ok := parseOrgHeadlines(path, lines, keywords)[oldNote.UUID]
+	if !ok {
+		return fmt.Errorf("%w: \"%s\" is not in %s anymore", ErrConflict, oldNote.Title, path)
+	}
+	// Other headlines of the file may change in the meantime, only this
+	// one has to be as it was loaded
+	if current := headline.note(lines, keywords); current.Body != oldNote.Body {
+		return fmt.Errorf("%w: \"%s\" was modified on disk", ErrConflict, oldNote.Title)
+	}
+
+	section := headline.render(lines, oldNote, newNote, keywords)
+	lines = slices.Concat(lines[:headline.start], section, lines[headline.end:])
+	if err := writeOrgLines(path, lines); err != nil {
+		return err
+	}
+	return self.reload(path, lines, headline.start, newNote)
+}
+
+// reload updates note with what will be loaded from the headline at line
+// start next time
+func (self *OrgImplementation) reload(path string, lines []string, start int, note *types.Note) error {
+	keywords := parseOrgKeywords(lines)
+	for uuid, headline := range parseOrgHeadlines(path, lines, keywords) {
+		if headline.start != start {
+			continue
+		}
+		reloaded := headline.note(lines, keywords)
+		reloaded.UUID = uuid
+		if reloaded.CreatedAt.IsZero() {
+			reloaded.CreatedAt = note.CreatedAt
+		}
+		if info, err := os.Stat(path); err == nil {
+			reloaded.ModifiedAt = info.ModTime()
+		}
+		reloaded.AdditionalProperties[pathProperty] = path
+		copySaved(note, reloaded, types.FlagNotify)
+		return nil
+	}
+	return errors.New("headline was not written")
+}
+
+// render returns the lines of the headline for newNote, keeping the
+// original lines for the parts which did not change since oldNote
+func (self *orgHeadline) render(lines []string, oldNote *types.Note, newNote *types.Note,
+	keywords orgKeywords) []string {
+	ret := make([]string, 0)
+
+	keyword := self.keyword
+	_, wasDone := oldNote.AdditionalProperties[types.PropertyCompleted]
+	_, done := newNote.AdditionalProperties[types.PropertyCompleted]
+	planning := maps.Clone(self.planning)
+	if done && !wasDone {
+		keyword = keywords.done[0]
+		closed := parseOrgTime(newNote.AdditionalProperties[types.PropertyCompleted])
+		if closed.IsZero() {
+			closed = time.Now()
+		}
+		planning["CLOSED"] = formatOrgTime(closed, false)
+	} else if !done && wasDone {
+		keyword = keywords.todo[0]
+		delete(planning, "CLOSED")
+	}
+	priority := newNote.AdditionalProperties[types.PropertyPriority]
+
+	if lines != nil && keyword == self.keyword && priority == self.priority &&
+		newNote.Title == oldNote.Title && slices.Equal(newNote.Tags, oldNote.Tags) {
+		ret = append(ret, lines[self.start])
+	} else {
+		parts := []string{"*"}
+		if keyword != "" {
+			parts = append(parts, keyword)
+		}
+		if priority != "" {
+			parts = append(parts, "[#"+strings.ToUpper(priority[:1])+"]")
+		}
+		parts = append(parts, strings.ReplaceAll(newNote.Title, "\n", " "))
+		if len(newNote.Tags) > 0 {
+			tags := make([]string, 0, len(newNote.Tags))
+			for _, tag := range newNote.Tags {
+				tags = append(tags, strings.ReplaceAll(tag, " ", "_"))
+			}
+			parts = append(parts, ":"+strings.Join(tags, ":")+":")
+		}
+		ret = append(ret, strings.Join(parts, " "))
+	}
+
+	if lines != nil && self.planningLine >= 0 && maps.Equal(planning, self.planning) {
+		ret = append(ret, lines[self.planningLine])
+	} else if len(planning) > 0 {
+		parts := make([]string, 0, 3)
+		for _, key := range []string{"CLOSED", "SCHEDULED", "DEADLINE"} {
+			if timestamp, ok := planning[key]; ok {
+				parts = append(parts, key+": "+timestamp)
+			}
+		}
+		indent := ""
+		if lines != nil && self.planningLine >= 0 {
+			line := lines[self.planningLine]
+			indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		}
+		ret = append(ret, indent+strings.Join(parts, " "))
+	}
+
+	ret = append(ret, self.renderDrawer(lines, oldNote, newNote)...)
+
+	if lines != nil && newNote.Body == oldNote.Body {
+		ret = append(ret, lines[self.bodyStart:self.end]...)
+	} else if newNote.Body != "" {
+		ret = append(ret, strings.Split(strings.TrimRight(newNote.Body, "\n"), "\n")...)
+	}
+	return ret
+}
+
+// renderDrawer updates properties changed since oldNote in the drawer
+func (self *orgHeadline) renderDrawer(lines []string, oldNote *types.Note, newNote *types.Note) []string {
+	drawer := make([]string, 0)
+	if lines != nil && self.drawerStart >= 0 {
+		drawer = append(drawer, lines[self.drawerStart+1:self.drawerEnd]...)
+	}
+
+	derived := map[string]bool{pathProperty: true, orgStateProperty: true,
+		orgScheduledProperty: true, orgDeadlineProperty: true,
+		types.PropertyCompleted: true, types.PropertyPriority: true}
+	keys := make([]string, 0, len(newNote.AdditionalProperties))
+	for key := range newNote.AdditionalProperties {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	changed := false
+	for _, key := range keys {
+		value := newNote.AdditionalProperties[key]
+		if oldValue, ok := oldNote.AdditionalProperties[key]; derived[key] || ok && oldValue == value {
+			continue
+		}
+		changed = true
+		line := ":" + key + ": " + value
+		index := slices.IndexFunc(drawer, func(line string) bool {
+			match := orgPropertyRe.FindStringSubmatch(line)
+			return match != nil && strings.EqualFold(match[1], key)
+		})
+		if index >= 0 {
+			drawer[index] = line
+		} else {
+			drawer = append(drawer, line)
+		}
+	}
+	for key := range oldNote.AdditionalProperties {
+		if _, ok := newNote.AdditionalProperties[key]; ok || derived[key] {
+			continue
+		}
+		changed = true
+		drawer = slices.DeleteFunc(drawer, func(line string) bool {
+			match := orgPropertyRe.FindStringSubmatch(line)
+			return match != nil && strings.EqualFold(match[1], key)
+		})
+	}
+
+	if !changed && lines != nil && self.drawerStart >= 0 {
+		return lines[self.drawerStart : self.drawerEnd+1]
+	}
+	if len(drawer) == 0 {
+		return nil
+	}
+	return slices.Concat([]string{":PROPERTIES:"}, drawer, []string{":END:"})
+}
+
+func (self *OrgImplementation) DeleteData(note *types.Note) error {
+	if self.perFile {
+		return os.Remove(note.AdditionalProperties[pathProperty])
+	}
+
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	path := note.AdditionalProperties[pathProperty]
+	lines, err := readOrgLines(path)
+	if err != nil {
+		return err
+	}
+	headline, ok := parseOrgHeadlines(path, lines, parseOrgKeywords(lines))[note.UUID]
+	if !ok {
+		return nil
+	}
+	return writeOrgLines(path, slices.Delete(lines, headline.start, headline.end))
+}
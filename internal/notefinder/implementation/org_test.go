@@ -0,0 +1,71 @@
+package implementation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"notefinder/internal/notefinder/types"
+)
+
+func TestOrgKeywordsWithEmptySide(t *testing.T) {
+	tests := []struct {
+		header     string
+		todo, done string
+	}{
+		{"#+TODO: TODO NEXT |", "TODO", "DONE"},
+		{"#+TODO: | DONE", "TODO", "DONE"},
+		{"#+TODO: DONE", "TODO", "DONE"},
+		{"#+TODO: NEXT | FINISHED", "NEXT", "FINISHED"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "todo.org")
+			if err := os.WriteFile(path, []byte(test.header+"\n* Existing\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			impl := NewOrgImplementation(map[string]string{"path": path})
+
+			note := types.NewNote(0, "Buy milk")
+			note.Type = types.NoteTypeTodoList
+			if err := impl.PutData(note); err != nil {
+				t.Fatal(err)
+			}
+			assertOrgHeadline(t, path, "* "+test.todo+" Buy milk")
+
+			completed := note.Clone()
+			completed.AdditionalProperties[types.PropertyCompleted] = "2024-01-03"
+			if err := impl.UpdateData(note, completed); err != nil {
+				t.Fatal(err)
+			}
+			assertOrgHeadline(t, path, "* "+test.done+" Buy milk")
+
+			reopened := completed.Clone()
+			delete(reopened.AdditionalProperties, types.PropertyCompleted)
+			if err := impl.UpdateData(completed, reopened); err != nil {
+				t.Fatal(err)
+			}
+			assertOrgHeadline(t, path, "* "+test.todo+" Buy milk")
+		})
+	}
+}
+
+// assertOrgHeadline checks the headline of the last note in the file
+func assertOrgHeadline(t *testing.T, path string, want string) {
+	t.Helper()
+	lines, err := readOrgLines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(lines[i], "* ") {
+			if lines[i] != want {
+				t.Errorf("headline = %q, want %q", lines[i], want)
+			}
+			return
+		}
+	}
+	t.Errorf("no headline in %q", lines)
+}
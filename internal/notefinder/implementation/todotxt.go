@@ -0,0 +1,385 @@
+package implementation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+todo.txt as described at https://github.com/todotxt/todo.txt, one note per
+task. The task text is the title, +projects and @contexts in it are tags and
+key:value pairs are properties. Completed tasks keep their priority as pri:A.
+done.txt next to todo.txt holds archived tasks.
+*/
+const (
+	todoFileName = "todo.txt"
+	doneFileName = "done.txt"
+	todoDate     = "2006-01-02"
+
+	todoFileProperty = "File"
+)
+
+var (
+	todoPriorityRe = regexp.MustCompile(`^\(([A-Z])\) `)
+	todoDateRe     = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}) `)
+	todoPropertyRe = regexp.MustCompile(`^([^\s:]+):(\S+)$`)
+)
+
+type TodoTxtImplementation struct {
+	dir string
+	mx  sync.Mutex
+}
+
+type todoTask struct {
+	completed   bool
+	completedAt string
+	priority    string
+	createdAt   string
+	text        string
+}
+
+func NewTodoTxtImplementation(config map[string]string) *TodoTxtImplementation {
+	dir := config["path"]
+	if filepath.Base(dir) == todoFileName {
+		dir = filepath.Dir(dir)
+	}
+	return &TodoTxtImplementation{dir: dir}
+}
+
+func (self *TodoTxtImplementation) CanWrite() (bool, error) {
+	file, err := os.CreateTemp(self.dir, tempFilePattern)
+	if err != nil {
+		return false, err
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	return true, nil
+}
+
+func (self *TodoTxtImplementation) SupportedProperties() map[string]types.Writable {
+	return map[string]types.Writable{"Title": true, "Tags": true,
+		types.PropertyCompleted: true, types.PropertyPriority: true}
+}
+
+func (self *TodoTxtImplementation) LoadData() (map[uint64]*types.Note, error) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	data := make(map[uint64]*types.Note)
+	for _, fileName := range []string{todoFileName, doneFileName} {
+		lines, err := self.readLines(fileName)
+		if err != nil {
+			return nil, err
+		}
+		for uuid, line := range todoUUIDs(fileName, lines) {
+			note := parseTodoLine(lines[line])
+			note.UUID = uuid
+			note.AdditionalProperties[todoFileProperty] = fileName
+			if fileName == doneFileName {
+				note.SetFlag(types.FlagArchived)
+			}
+			data[uuid] = note
+		}
+	}
+	return data, nil
+}
+
+func (self *TodoTxtImplementation) PutData(note *types.Note) error {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	lines, err := self.readLines(todoFileName)
+	if err != nil {
+		return err
+	}
+
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = time.Now()
+	}
+	task := todoTask{createdAt: note.CreatedAt.Format(todoDate)}
+	line := renderTodoTask(task, &types.Note{}, note)
+	lines = append(lines, line)
+	if err := self.writeLines(todoFileName, lines); err != nil {
+		return err
+	}
+
+	copySaved(note, self.reparse(todoFileName, lines, len(lines)-1, note.Source), types.FlagArchived)
+	return nil
+}
+
+// UpdateData rewrites the task line in place, so the order of tasks is kept
+func (self *TodoTxtImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	fileName := todoNoteFile(oldNote)
+	lines, err := self.readLines(fileName)
+	if err != nil {
+		return err
+	}
+	index, ok := todoUUIDs(fileName, lines)[oldNote.UUID]
+	if !ok {
+		return fmt.Errorf("%w: \"%s\" is not in %s anymore", ErrConflict, oldNote.Title, fileName)
+	}
+
+	task := parseTodoTask(lines[index])
+	lines[index] = renderTodoTask(task, oldNote, newNote)
+	if err := self.writeLines(fileName, lines); err != nil {
+		return err
+	}
+
+	copySaved(newNote, self.reparse(fileName, lines, index, newNote.Source), types.FlagArchived)
+	return nil
+}
+
+func (self *TodoTxtImplementation) DeleteData(note *types.Note) error {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	fileName := todoNoteFile(note)
+	lines, err := self.readLines(fileName)
+	if err != nil {
+		return err
+	}
+	index, ok := todoUUIDs(fileName, lines)[note.UUID]
+	if !ok {
+		return nil
+	}
+	return self.writeLines(fileName, slices.Delete(lines, index, index+1))
+}
+
+func todoNoteFile(note *types.Note) string {
+	if fileName := note.AdditionalProperties[todoFileProperty]; fileName == doneFileName {
+		return doneFileName
+	}
+	return todoFileName
+}
+
+// readLines returns lines of the file, a missing file has none
+func (self *TodoTxtImplementation) readLines(fileName string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(self.dir, fileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+func (self *TodoTxtImplementation) writeLines(fileName string, lines []string) error {
+	path := filepath.Join(self.dir, fileName)
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return writeFileAtomic(path, []byte(content), perm)
+}
+
+// reparse returns the note for the line just written, with the UUID it
+// will be loaded with next time
+func (self *TodoTxtImplementation) reparse(fileName string, lines []string, index int,
+	source *types.Notebook) *types.Note {
+	note := parseTodoLine(lines[index])
+	note.Source = source
+	note.AdditionalProperties[todoFileProperty] = fileName
+	if fileName == doneFileName {
+		note.SetFlag(types.FlagArchived)
+	}
+	for uuid, line := range todoUUIDs(fileName, lines) {
+		if line == index {
+			note.UUID = uuid
+		}
+	}
+	return note
+}
+
+// todoUUIDs maps UUIDs to line numbers. Tasks have no ids, so the UUID comes
+// from the line itself and the number of identical lines before it.
+func todoUUIDs(fileName string, lines []string) map[uint64]int {
+	ret := make(map[uint64]int, len(lines))
+	seen := make(map[string]int)
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		ret[stringUUID(fileName, line, strconv.Itoa(seen[line]))] = i
+		seen[line]++
+	}
+	return ret
+}
+
+func parseTodoTask(line string) todoTask {
+	var task todoTask
+	rest := line
+
+	if strings.HasPrefix(rest, "x ") {
+		task.completed = true
+		rest = rest[2:]
+		if match := todoDateRe.FindStringSubmatch(rest); match != nil {
+			task.completedAt = match[1]
+			rest = rest[len(match[0]):]
+		}
+	}
+	if match := todoPriorityRe.FindStringSubmatch(rest); match != nil {
+		task.priority = match[1]
+		rest = rest[len(match[0]):]
+	}
+	if match := todoDateRe.FindStringSubmatch(rest); match != nil {
+		task.createdAt = match[1]
+		rest = rest[len(match[0]):]
+	}
+	task.text = rest
+
+	return task
+}
+
+func parseTodoLine(line string) *types.Note {
+	task := parseTodoTask(line)
+
+	note := types.NewNote(0, task.text)
+	note.Type = types.NoteTypeTodoList
+	note.Markup = types.MarkupTodoTxt
+	note.AdditionalProperties = make(map[string]string)
+	for _, word := range strings.Fields(task.text) {
+		if len(word) > 1 && (word[0] == '+' || word[0] == '@') {
+			if !slices.Contains(note.Tags, word) {
+				note.Tags = append(note.Tags, word)
+			}
+			continue
+		}
+		if match := todoPropertyRe.FindStringSubmatch(word); match != nil &&
+			!strings.Contains(match[2], "//") {
+			if match[1] == "pri" {
+				note.AdditionalProperties[types.PropertyPriority] = match[2]
+			} else {
+				note.AdditionalProperties[match[1]] = match[2]
+			}
+		}
+	}
+
+	if task.priority != "" {
+		note.AdditionalProperties[types.PropertyPriority] = task.priority
+	}
+	if task.completed {
+		note.AdditionalProperties[types.PropertyCompleted] = task.completedAt
+		if task.completedAt == "" {
+			note.AdditionalProperties[types.PropertyCompleted] = "x"
+		}
+	}
+	if created, err := time.ParseInLocation(todoDate, task.createdAt, time.Local); err == nil {
+		note.CreatedAt = created
+	}
+	note.ModifiedAt = note.CreatedAt
+	if completed, err := time.ParseInLocation(todoDate, task.completedAt, time.Local); err == nil {
+		note.ModifiedAt = completed
+	}
+
+	return note
+}
+
+// renderTodoTask returns the task line for newNote. The title is the task
+// text, tags and properties changed since oldNote are added to or removed
+// from it.
+func renderTodoTask(task todoTask, oldNote *types.Note, newNote *types.Note) string {
+	words := strings.Fields(strings.ReplaceAll(newNote.Title, "\n", " "))
+
+	for _, tag := range oldNote.Tags {
+		if !slices.Contains(newNote.Tags, tag) {
+			words = slices.DeleteFunc(words, func(word string) bool { return word == tag })
+		}
+	}
+	for _, tag := range newNote.Tags {
+		if len(tag) == 0 || tag[0] != '+' && tag[0] != '@' {
+			tag = "+" + strings.ReplaceAll(tag, " ", "_")
+		}
+		if !slices.Contains(words, tag) {
+			words = append(words, tag)
+		}
+	}
+
+	priority := newNote.AdditionalProperties[types.PropertyPriority]
+	_, completed := newNote.AdditionalProperties[types.PropertyCompleted]
+	for key, value := range newNote.AdditionalProperties {
+		if key == types.PropertyPriority || key == types.PropertyCompleted ||
+			key == todoFileProperty || key == pathProperty {
+			continue
+		}
+		words = setTodoProperty(words, key, value)
+	}
+	for key := range oldNote.AdditionalProperties {
+		if _, ok := newNote.AdditionalProperties[key]; !ok {
+			words = setTodoProperty(words, key, "")
+		}
+	}
+	// Completed tasks keep their priority as a property
+	if completed && priority != "" {
+		words = setTodoProperty(words, "pri", priority)
+	} else {
+		words = setTodoProperty(words, "pri", "")
+	}
+
+	parts := make([]string, 0, 4)
+	if completed {
+		parts = append(parts, "x")
+		completedAt := newNote.AdditionalProperties[types.PropertyCompleted]
+		if _, err := time.Parse(todoDate, completedAt); err == nil {
+			parts = append(parts, completedAt)
+		} else if !task.completed {
+			parts = append(parts, time.Now().Format(todoDate))
+		}
+	} else if priority != "" {
+		parts = append(parts, "("+strings.ToUpper(priority[:1])+")")
+	}
+	if task.createdAt != "" {
+		parts = append(parts, task.createdAt)
+	}
+
+	// Keep the text as it was if nothing changed, words collapse spaces
+	text := strings.Join(words, " ")
+	if strings.Join(strings.Fields(task.text), " ") == text {
+		text = task.text
+	}
+	if text == "" {
+		text = "-"
+	}
+	return strings.Join(append(parts, text), " ")
+}
+
+// setTodoProperty replaces key:value in words, appends it if missing or
+// removes it if value is empty
+func setTodoProperty(words []string, key string, value string) []string {
+	prefix := key + ":"
+	for i, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			if value == "" {
+				return slices.Delete(words, i, i+1)
+			}
+			words[i] = prefix + value
+			return words
+		}
+	}
+	if value != "" {
+		words = append(words, prefix+value)
+	}
+	return words
+}
@@ -0,0 +1,64 @@
+package implementation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"notefinder/internal/notefinder/types"
+)
+
+func TestTodoTxtUpdateKeepsAnnotation(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, todoFileName),
+		[]byte("(A) 2024-01-01 Call mom +family\n2024-01-02 Buy milk\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impl := NewTodoTxtImplementation(map[string]string{"path": dir})
+
+	data, err := impl.LoadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var oldNote *types.Note
+	for _, note := range data {
+		if note.Title == "Call mom +family" {
+			oldNote = note
+		}
+	}
+	if oldNote == nil {
+		t.Fatalf("task not loaded: %v", data)
+	}
+
+	newNote := oldNote.Clone()
+	newNote.Annotation = "ask about the trip"
+	newNote.SetFlag(types.FlagStarred)
+	newNote.AdditionalProperties[types.PropertyCompleted] = "2024-01-03"
+	if err := impl.UpdateData(oldNote, newNote); err != nil {
+		t.Fatal(err)
+	}
+
+	if newNote.Annotation != "ask about the trip" {
+		t.Errorf("annotation = %q, want it kept", newNote.Annotation)
+	}
+	if !newNote.FlagIsSet(types.FlagStarred) {
+		t.Error("star was dropped")
+	}
+
+	data, err = impl.LoadData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, ok := data[newNote.UUID]
+	if !ok {
+		t.Fatalf("task %d not found after reload", newNote.UUID)
+	}
+	if reloaded.Title != newNote.Title {
+		t.Errorf("title = %q, want %q", reloaded.Title, newNote.Title)
+	}
+	if reloaded.AdditionalProperties[types.PropertyCompleted] != "2024-01-03" {
+		t.Errorf("completed = %q, want 2024-01-03",
+			reloaded.AdditionalProperties[types.PropertyCompleted])
+	}
+}
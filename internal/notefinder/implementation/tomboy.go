@@ -0,0 +1,532 @@
+package implementation
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+Tomboy and Gnote keep every note in its own <guid>.note XML file. The first
+line of the note content is the title, the rest is converted to Markdown.
+Notebooks are tags named system:notebook:<name>.
+*/
+const (
+	tomboyExt             = ".note"
+	tomboyNotebookPrefix  = "system:notebook:"
+	tomboyPinnedTag       = "system:pinned"
+	tomboyTemplateTag     = "system:template"
+	tomboySystemTagPrefix = "system:"
+	tomboyTimeLayout      = "2006-01-02T15:04:05.0000000-07:00"
+	tomboyBackupDir       = "Backup"
+)
+
+type tomboyRoot struct {
+	// Relative to the home directory
+	path  string
+	label string
+}
+
+// Places where Tomboy, Gnote and forks keep notes
+var tomboyRoots = []tomboyRoot{
+	{".local/share/gnote", "Gnote"},
+	{".var/app/org.gnome.Gnote/data/gnote", "Gnote Flatpak"},
+	{".local/share/tomboy", "Tomboy"},
+	{".tomboy", "Tomboy"},
+	{".local/share/tomboy-ng", "Tomboy-ng"},
+	{"Library/Application Support/Tomboy", "Tomboy"},
+}
+
+var (
+	tomboyTitleRe       = regexp.MustCompile(`(?s)<title>.*?</title>`)
+	tomboyContentRe     = regexp.MustCompile(`(?s)(<note-content[^>]*>)(.*)(</note-content>)`)
+	tomboyChangeDateRe  = regexp.MustCompile(`(<last-(?:metadata-)?change-date>)[^<]*(</last-(?:metadata-)?change-date>)`)
+	tomboyTagsRe        = regexp.MustCompile(`(?s)[ \t]*<tags\s*/>\n?|[ \t]*<tags>.*?</tags>\n?`)
+	tomboyTagsAnchorRe  = regexp.MustCompile(`[ \t]*<open-on-startup>|</note>`)
+	tomboyTitleLineRe   = regexp.MustCompile(`^[^\n]*`)
+	tomboyMarkdownSizes = map[string]string{"huge": "# ", "large": "## "}
+)
+
+type TomboyImplementation struct {
+	path string
+}
+
+type tomboyDocument struct {
+	Title   string `xml:"title"`
+	Content struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"text"`
+	LastChange string   `xml:"last-change-date"`
+	Created    string   `xml:"create-date"`
+	Tags       []string `xml:"tags>tag"`
+}
+
+func NewTomboyImplementation(config map[string]string) *TomboyImplementation {
+	return &TomboyImplementation{path: config["path"]}
+}
+
+func (self *TomboyImplementation) CanWrite() (bool, error) {
+	file, err := os.CreateTemp(self.path, tempFilePattern)
+	if err != nil {
+		return false, err
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	return true, nil
+}
+
+func (self *TomboyImplementation) SupportedProperties() map[string]types.Writable {
+	return map[string]types.Writable{"Title": true, "Body": true, "Tags": true, "Starred": true}
+}
+
+func (self *TomboyImplementation) LoadData() (map[uint64]*types.Note, error) {
+	items, err := os.ReadDir(self.path)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[uint64]*types.Note)
+	for _, item := range items {
+		if item.IsDir() || filepath.Ext(item.Name()) != tomboyExt {
+			continue
+		}
+		note, err := self.readNote(item.Name())
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if note != nil {
+			data[note.UUID] = note
+		}
+	}
+	return data, nil
+}
+
+// readNote returns nil for templates
+func (self *TomboyImplementation) readNote(fileName string) (*types.Note, error) {
+	path := filepath.Join(self.path, fileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var document tomboyDocument
+	if err := xml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if slices.Contains(document.Tags, tomboyTemplateTag) {
+		return nil, nil
+	}
+	text, err := tomboyToMarkdown(document.Content.Inner)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	note := types.NewNote(stringUUID(strings.TrimSuffix(fileName, tomboyExt)), document.Title)
+	_, body, _ := strings.Cut(text, "\n")
+	note.Set("Body", strings.Trim(body, "\n"), true)
+	note.Markup = types.Markdown
+	note.CreatedAt = birthTime(path, info)
+	if created, err := time.Parse(time.RFC3339Nano, document.Created); err == nil {
+		note.CreatedAt = created
+	}
+	note.ModifiedAt = info.ModTime()
+	note.AdditionalProperties = map[string]string{pathProperty: fileName}
+
+	for _, tag := range document.Tags {
+		switch {
+		case tag == tomboyPinnedTag:
+			note.SetFlag(types.FlagStarred)
+		case strings.HasPrefix(tag, tomboyNotebookPrefix):
+			note.Tags = append(note.Tags, strings.TrimPrefix(tag, tomboyNotebookPrefix))
+		case !strings.HasPrefix(tag, tomboySystemTagPrefix):
+			note.Tags = append(note.Tags, tag)
+		}
+	}
+	return note, nil
+}
+
+// tomboyToMarkdown converts the contents of the <text> element
+func tomboyToMarkdown(content string) (string, error) {
+	type mark struct {
+		name  string
+		start int
+	}
+
+	var out strings.Builder
+	marks := make([]mark, 0)
+	listDepth := 0
+	atLineStart := func() bool {
+		return out.Len() == 0 || strings.HasSuffix(out.String(), "\n")
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch token := token.(type) {
+		case xml.CharData:
+			out.WriteString(string(token))
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "list":
+				listDepth++
+			case "list-item":
+				if !atLineStart() {
+					out.WriteString("\n")
+				}
+				out.WriteString(strings.Repeat("  ", max(listDepth-1, 0)) + "- ")
+			default:
+				marks = append(marks, mark{token.Name.Local, out.Len()})
+			}
+		case xml.EndElement:
+			switch token.Name.Local {
+			case "list":
+				listDepth--
+				if !atLineStart() {
+					out.WriteString("\n")
+				}
+			case "list-item":
+			default:
+				if len(marks) == 0 {
+					continue
+				}
+				current := marks[len(marks)-1]
+				marks = marks[:len(marks)-1]
+				whole := out.String()
+				before, text := whole[:current.start], whole[current.start:]
+				out.Reset()
+				out.WriteString(before)
+				out.WriteString(tomboyMarkToMarkdown(current.name, text,
+					before == "" || strings.HasSuffix(before, "\n")))
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+func tomboyMarkToMarkdown(name string, text string, lineStart bool) string {
+	switch name {
+	case "bold":
+		return wrapLines(text, "**", "**")
+	case "italic":
+		return wrapLines(text, "*", "*")
+	case "strikethrough":
+		return wrapLines(text, "~~", "~~")
+	case "highlight":
+		return wrapLines(text, "==", "==")
+	case "monospace":
+		return wrapLines(text, "`", "`")
+	case "url":
+		return fmt.Sprintf("[%s](%s)", text, strings.TrimSpace(text))
+	case "internal", "broken":
+		return "[[" + text + "]]"
+	case "huge", "large":
+		// Sized lines are what Tomboy has for headings
+		trimmed := strings.TrimSpace(text)
+		if lineStart && trimmed != "" && !strings.Contains(trimmed, "\n") {
+			if strings.HasPrefix(trimmed, "**") && strings.HasSuffix(trimmed, "**") && len(trimmed) > 4 {
+				trimmed = trimmed[2 : len(trimmed)-2]
+			}
+			return tomboyMarkdownSizes[name] + trimmed + text[len(strings.TrimRight(text, " \t\n")):]
+		}
+	}
+	return text
+}
+
+// markdownToTomboy converts the body back into note content markup
+func markdownToTomboy(body string) string {
+	syntax := inlineSyntax{
+		escape: html.EscapeString,
+		code: func(text string) string {
+			return "<monospace>" + html.EscapeString(text) + "</monospace>"
+		},
+		link: func(text string, url string) string {
+			link := "<link:url>" + html.EscapeString(url) + "</link:url>"
+			if text == "" || text == url {
+				return link
+			}
+			return html.EscapeString(text) + " (" + link + ")"
+		},
+		wikiLink: func(target string, label string) string {
+			return "<link:internal>" + html.EscapeString(target) + "</link:internal>"
+		},
+		bold:      [2]string{"<bold>", "</bold>"},
+		italic:    [2]string{"<italic>", "</italic>"},
+		strike:    [2]string{"<strikethrough>", "</strikethrough>"},
+		highlight: [2]string{"<highlight>", "</highlight>"},
+	}
+
+	var out strings.Builder
+	depth := 0
+	closeLists := func(to int) {
+		for ; depth > to; depth-- {
+			out.WriteString("</list-item></list>")
+		}
+	}
+	for _, line := range strings.Split(body, "\n") {
+		if item, ok := parseMarkdownListItem(line); ok && !item.ordered {
+			level := min(item.depth+1, depth+1)
+			closeLists(level)
+			if depth == level {
+				out.WriteString("</list-item>")
+			}
+			for ; depth < level; depth++ {
+				out.WriteString("<list>")
+			}
+			text := item.text
+			if item.checkbox != 0 {
+				text = "[" + string(item.checkbox) + "] " + text
+			}
+			out.WriteString(`<list-item dir="ltr">` + markdownToInline(text, syntax) + "\n")
+			continue
+		}
+		closeLists(0)
+		if match := markdownHeadingRe.FindStringSubmatch(line); match != nil {
+			size := "large"
+			if len(match[1]) == 1 {
+				size = "huge"
+			}
+			out.WriteString("<size:" + size + ">" + markdownToInline(match[2], syntax) +
+				"</size:" + size + ">\n")
+			continue
+		}
+		out.WriteString(markdownToInline(line, syntax) + "\n")
+	}
+	closeLists(0)
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+func (self *TomboyImplementation) PutData(note *types.Note) error {
+	guid, err := newTomboyGUID()
+	if err != nil {
+		return err
+	}
+	fileName := guid + tomboyExt
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = time.Now()
+	}
+	now := time.Now().Format(tomboyTimeLayout)
+
+	content := `<?xml version="1.0" encoding="utf-8"?>
+<note version="0.3" xmlns:link="http://beatniksoftware.com/tomboy/link" xmlns:size="http://beatniksoftware.com/tomboy/size" xmlns="http://beatniksoftware.com/tomboy">
+  <title></title>
+  <text xml:space="preserve"><note-content version="0.1"></note-content></text>
+  <last-change-date>` + now + `</last-change-date>
+  <last-metadata-change-date>` + now + `</last-metadata-change-date>
+  <create-date>` + note.CreatedAt.Format(tomboyTimeLayout) + `</create-date>
+  <cursor-position>0</cursor-position>
+  <selection-bound-position>-1</selection-bound-position>
+  <width>450</width>
+  <height>360</height>
+  <x>0</x>
+  <y>0</y>
+  <open-on-startup>False</open-on-startup>
+</note>
+`
+	content = setTomboyNote(content, &types.Note{}, note, nil)
+	if err := writeFileAtomic(filepath.Join(self.path, fileName), []byte(content), 0644); err != nil {
+		log.Println(err)
+		return err
+	}
+	return self.reload(fileName, note)
+}
+
+// UpdateData edits the note file in place, elements Tomboy uses for its own
+// purposes, e.g. window position, are kept as they are
+func (self *TomboyImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	fileName, ok := oldNote.AdditionalProperties[pathProperty]
+	if !ok {
+		return errors.New("note does not belong to the notebook")
+	}
+	path := filepath.Join(self.path, fileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !oldNote.ModifiedAt.IsZero() && !info.ModTime().Equal(oldNote.ModifiedAt) {
+		return fmt.Errorf("%w: \"%s\" was modified on disk", ErrConflict, oldNote.Title)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var document tomboyDocument
+	if err := xml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	newContent := setTomboyNote(string(content), oldNote, newNote, document.Tags)
+	if err := writeFileAtomic(path, []byte(newContent), info.Mode().Perm()); err != nil {
+		log.Println(err)
+		return err
+	}
+	return self.reload(fileName, newNote)
+}
+
+// setTomboyNote writes fields changed since oldNote into the XML text.
+// System tags other than notebooks and pinning are kept from tags.
+func setTomboyNote(content string, oldNote *types.Note, newNote *types.Note, tags []string) string {
+	now := time.Now().Format(tomboyTimeLayout)
+	content = tomboyChangeDateRe.ReplaceAllString(content, "${1}"+now+"${2}")
+
+	title := strings.ReplaceAll(newNote.Title, "\n", " ")
+	if newNote.Title != oldNote.Title {
+		content = tomboyTitleRe.ReplaceAllLiteralString(content,
+			"<title>"+html.EscapeString(title)+"</title>")
+	}
+	match := tomboyContentRe.FindStringSubmatchIndex(content)
+	if match != nil {
+		noteContent := content[match[4]:match[5]]
+		if newNote.Body != oldNote.Body {
+			noteContent = html.EscapeString(title)
+			if newNote.Body != "" {
+				noteContent += "\n\n" + markdownToTomboy(newNote.Body)
+			}
+		} else if newNote.Title != oldNote.Title {
+			noteContent = tomboyTitleLineRe.ReplaceAllLiteralString(noteContent, html.EscapeString(title))
+		}
+		content = content[:match[4]] + noteContent + content[match[5]:]
+	}
+
+	newTags := make([]string, 0)
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, tomboySystemTagPrefix) && tag != tomboyPinnedTag &&
+			!strings.HasPrefix(tag, tomboyNotebookPrefix) {
+			newTags = append(newTags, tag)
+		}
+	}
+	// A note is in one notebook at most. It stays in the one it was in,
+	// otherwise the first new tag becomes the notebook since that is what
+	// the Tomboy and Gnote interfaces show, the rest are plain tags.
+	var notebook string
+	for _, tag := range tags {
+		if name, ok := strings.CutPrefix(tag, tomboyNotebookPrefix); ok &&
+			slices.Contains(newNote.Tags, name) {
+			notebook = name
+		}
+	}
+	if notebook == "" {
+		for _, tag := range newNote.Tags {
+			if !slices.Contains(tags, tag) {
+				notebook = tag
+				break
+			}
+		}
+	}
+	for _, tag := range newNote.Tags {
+		if tag == notebook {
+			tag = tomboyNotebookPrefix + tag
+		}
+		newTags = append(newTags, tag)
+	}
+	if newNote.FlagIsSet(types.FlagStarred) {
+		newTags = append(newTags, tomboyPinnedTag)
+	}
+	var tagsXML string
+	if len(newTags) > 0 {
+		tagsXML = "  <tags>\n"
+		for _, tag := range newTags {
+			tagsXML += "    <tag>" + html.EscapeString(tag) + "</tag>\n"
+		}
+		tagsXML += "  </tags>\n"
+	}
+	if tomboyTagsRe.MatchString(content) {
+		content = tomboyTagsRe.ReplaceAllLiteralString(content, tagsXML)
+	} else if anchor := tomboyTagsAnchorRe.FindStringIndex(content); anchor != nil {
+		content = content[:anchor[0]] + tagsXML + content[anchor[0]:]
+	}
+	return content
+}
+
+func (self *TomboyImplementation) reload(fileName string, note *types.Note) error {
+	reloaded, err := self.readNote(fileName)
+	if err != nil {
+		return err
+	}
+	if reloaded == nil {
+		return errors.New("note was saved as a template")
+	}
+	copySaved(note, reloaded, types.FlagStarred)
+	return nil
+}
+
+// DeleteData moves the note into the backup directory like Tomboy does
+func (self *TomboyImplementation) DeleteData(note *types.Note) error {
+	fileName, ok := note.AdditionalProperties[pathProperty]
+	if !ok {
+		return errors.New("note does not belong to the notebook")
+	}
+	backupDir := filepath.Join(self.path, tomboyBackupDir)
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(self.path, fileName), filepath.Join(backupDir, fileName))
+}
+
+func newTomboyGUID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	buf[6] = buf[6]&0x0f | 0x40
+	buf[8] = buf[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:]), nil
+}
+
+// GetTomboyFiles returns note directories of Tomboy and Gnote by application
+// name
+func GetTomboyFiles() map[string]string {
+	files := make(map[string]string)
+
+	user, _ := user.Current()
+	seen := make(map[string]bool)
+	for _, root := range tomboyRoots {
+		dir := filepath.Join(user.HomeDir, root.path)
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		notes, _ := filepath.Glob(filepath.Join(dir, "*"+tomboyExt))
+		if len(notes) == 0 {
+			continue
+		}
+		seen[resolved] = true
+
+		name := root.label
+		for i := 2; ; i++ {
+			if _, taken := files[name]; !taken {
+				break
+			}
+			name = fmt.Sprintf("%s %d", root.label, i)
+		}
+		log.Println(name, dir)
+		files[name] = dir
+	}
+
+	return files
+}
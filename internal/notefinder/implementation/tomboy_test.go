@@ -0,0 +1,83 @@
+package implementation
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"notefinder/internal/notefinder/types"
+)
+
+func TestTomboyMarkdownRoundTrip(t *testing.T) {
+	for _, body := range []string{
+		"plain text",
+		"**bold** and *italic* and ~~gone~~ and ==marked== and `code`",
+		"see [[Other note]] and [https://example.org](https://example.org)",
+		"# Heading\ntext\n## Smaller",
+		"- milk\n- eggs\n  - brown\n  - white\n- bread\nafter",
+		"a & b < c",
+	} {
+		content := markdownToTomboy(body)
+		got, err := tomboyToMarkdown(`<note-content version="0.1">Title` + "\n\n" + content +
+			`</note-content>`)
+		if err != nil {
+			t.Fatalf("%q: %v", body, err)
+		}
+		if want := "Title\n\n" + body; got != want {
+			t.Errorf("round trip of %q\ngot  %q\nwant %q\nvia  %q", body, got, want, content)
+		}
+	}
+}
+
+func TestTomboyContentRoundTrip(t *testing.T) {
+	for _, content := range []string{
+		"Some <bold>bold</bold> and <italic>it</italic>, see <link:internal>Other</link:internal>",
+		`<list><list-item dir="ltr">milk` + "\n" + `</list-item><list-item dir="ltr">eggs` + "\n" +
+			`<list><list-item dir="ltr">brown` + "\n" + `</list-item></list></list-item></list>end`,
+		"<size:huge>Heading</size:huge>\n<monospace>x</monospace> &amp; <strikethrough>y</strikethrough>",
+	} {
+		markdown, err := tomboyToMarkdown(`<note-content version="0.1">` + content + `</note-content>`)
+		if err != nil {
+			t.Fatalf("%q: %v", content, err)
+		}
+		if got := markdownToTomboy(markdown); got != content {
+			t.Errorf("round trip of %q\ngot  %q\nvia  %q", content, got, markdown)
+		}
+	}
+}
+
+func TestTomboyOneNotebook(t *testing.T) {
+	tagRe := regexp.MustCompile(`<tag>([^<]*)</tag>`)
+	tests := []struct {
+		tags    []string
+		newTags []string
+		want    []string
+	}{
+		// The notebook is kept, new tags are plain
+		{[]string{"system:notebook:Work"}, []string{"Work", "urgent", "home"},
+			[]string{"system:notebook:Work", "urgent", "home"}},
+		// The first new tag becomes the notebook
+		{nil, []string{"ideas", "later"}, []string{"system:notebook:ideas", "later"}},
+		{[]string{"later"}, []string{"later", "ideas", "books"},
+			[]string{"later", "system:notebook:ideas", "books"}},
+		// Leaving the notebook moves the note to the first new tag
+		{[]string{"system:notebook:Work", "system:template-x"}, []string{"home", "Chores"},
+			[]string{"system:template-x", "system:notebook:home", "Chores"}},
+	}
+
+	for _, test := range tests {
+		content := "<note><title>T</title><text><note-content>T</note-content></text>\n</note>"
+		oldNote := types.NewNote(1, "T")
+		newNote := oldNote.Clone()
+		newNote.Tags = test.newTags
+
+		got := make([]string, 0)
+		content = setTomboyNote(content, oldNote, newNote, test.tags)
+		for _, match := range tagRe.FindAllStringSubmatch(content, -1) {
+			got = append(got, match[1])
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("tags %v -> %v: got %v, want %v", test.tags, test.newTags, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package implementation
+
+import (
+	"hash/fnv"
+)
+
+// stringUUID derives a stable UUID for items which have no numeric id of
+// their own
+func stringUUID(parts ...string) uint64 {
+	hash := fnv.New64a()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hash.Sum64()
+}
@@ -0,0 +1,540 @@
+package implementation
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+A Zim desktop wiki notebook, a tree of .txt pages in Zim wiki syntax. A page
+in a namespace, e.g. Projects:Garden, gets the namespace as a tag along with
+the @tags in its text. Page text is converted to Markdown and back.
+*/
+const (
+	zimExt          = ".txt"
+	zimNotebookFile = "notebook.zim"
+	zimContentType  = "Content-Type: text/x-zim-wiki"
+	zimVerbatim     = "'''"
+	zimTimeLayout   = "2006-01-02T15:04:05-07:00"
+	zimCreatedLine  = "Created Monday 02 January 2006"
+)
+
+// Notebook lists of Zim, relative to the home directory
+var zimNotebookLists = []string{
+	".config/zim/notebooks.list",
+	".var/app/org.zim_wiki.Zim/config/zim/notebooks.list",
+}
+
+var (
+	zimHeadingRe  = regexp.MustCompile(`^(={1,6})\s*(.*?)\s*=+\s*$`)
+	zimListRe     = regexp.MustCompile(`^(\t*)(\*|\[[ *x<>]\]|\d+\.|[a-zA-Z]\.)\s+(.*)$`)
+	zimTagRe      = regexp.MustCompile(`(?:^|\s)@(\w+)`)
+	zimInlineRe   = regexp.MustCompile(`''(.+?)''|\[\[([^\]|]+)(?:\|([^\]]*))?\]\]|\*\*(.+?)\*\*|//(.+?)//|~~(.+?)~~|__(.+?)__`)
+	zimCheckboxes = map[string]string{"[ ]": "[ ] ", "[*]": "[x] ", "[x]": "[x] ", "[>]": "[ ] ", "[<]": "[ ] "}
+)
+
+var zimSyntax = inlineSyntax{
+	escape: func(text string) string { return text },
+	code:   func(text string) string { return "''" + text + "''" },
+	link: func(text string, url string) string {
+		if text == "" || text == url {
+			return "[[" + url + "]]"
+		}
+		return "[[" + url + "|" + text + "]]"
+	},
+	wikiLink: func(target string, label string) string {
+		if label == "" {
+			return "[[" + target + "]]"
+		}
+		return "[[" + target + "|" + label + "]]"
+	},
+	bold:      [2]string{"**", "**"},
+	italic:    [2]string{"//", "//"},
+	strike:    [2]string{"~~", "~~"},
+	highlight: [2]string{"__", "__"},
+}
+
+type ZimImplementation struct {
+	path string
+}
+
+func NewZimImplementation(config map[string]string) *ZimImplementation {
+	return &ZimImplementation{path: config["path"]}
+}
+
+func (self *ZimImplementation) CanWrite() (bool, error) {
+	file, err := os.CreateTemp(self.path, tempFilePattern)
+	if err != nil {
+		return false, err
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	return true, nil
+}
+
+// SupportedProperties has tags read only, they come from the namespace
+func (self *ZimImplementation) SupportedProperties() map[string]types.Writable {
+	return map[string]types.Writable{"Title": true, "Body": true, "Tags": false}
+}
+
+func (self *ZimImplementation) LoadData() (map[uint64]*types.Note, error) {
+	data := make(map[uint64]*types.Note)
+
+	err := filepath.WalkDir(self.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == self.path {
+				return err
+			}
+			log.Println(err)
+			return nil
+		}
+		// Index, trash and version control
+		if d.IsDir() && path != self.path && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || filepath.Ext(path) != zimExt || tempFileRe.MatchString(d.Name()) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(self.path, path)
+		if err != nil {
+			return err
+		}
+		note, err := self.readNote(relPath)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		data[note.UUID] = note
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (self *ZimImplementation) readNote(relPath string) (*types.Note, error) {
+	path := filepath.Join(self.path, relPath)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	headers, text := splitZimPage(string(content))
+	_, text = cutZimHeading(text)
+
+	names := strings.Split(strings.TrimSuffix(relPath, zimExt), string(filepath.Separator))
+	for i := range names {
+		names[i] = zimPageName(names[i])
+	}
+	note := types.NewNote(stringUUID(relPath), names[len(names)-1])
+	note.Set("Body", zimToMarkdown(text), true)
+	note.Markup = types.Markdown
+	note.CreatedAt = birthTime(path, info)
+	if created, err := time.Parse(zimTimeLayout, headers["Creation-Date"]); err == nil {
+		note.CreatedAt = created
+	}
+	note.ModifiedAt = info.ModTime()
+	note.AdditionalProperties = map[string]string{pathProperty: relPath}
+
+	if len(names) > 1 {
+		note.Tags = append(note.Tags, strings.Join(names[:len(names)-1], ":"))
+	}
+	for _, match := range zimTagRe.FindAllStringSubmatch(text, -1) {
+		if !slices.Contains(note.Tags, match[1]) {
+			note.Tags = append(note.Tags, match[1])
+		}
+	}
+	return note, nil
+}
+
+// zimPageName decodes file names, Zim stores spaces as underscores
+func zimPageName(fileName string) string {
+	name := strings.ReplaceAll(fileName, "_", " ")
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return name
+}
+
+func zimFileName(title string) string {
+	return strings.ReplaceAll(normalizeTitle(title), " ", "_")
+}
+
+// splitZimPage separates the headers from the page text, pages written by
+// old versions of Zim may have none
+func splitZimPage(content string) (map[string]string, string) {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	headers := make(map[string]string)
+	if !strings.HasPrefix(content, "Content-Type:") {
+		return headers, content
+	}
+	block, text, _ := strings.Cut(content, "\n\n")
+	for _, line := range strings.Split(block, "\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			headers[key] = strings.TrimSpace(value)
+		}
+	}
+	return headers, text
+}
+
+// cutZimHeading removes the top level heading Zim puts on every page, it
+// repeats the page name
+func cutZimHeading(text string) (string, string) {
+	first, rest, _ := strings.Cut(text, "\n")
+	if match := zimHeadingRe.FindStringSubmatch(first); match != nil && len(match[1]) == 6 {
+		return match[2], rest
+	}
+	return "", text
+}
+
+func zimToMarkdown(text string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	verbatim := false
+	for i, line := range lines {
+		if strings.TrimSpace(line) == zimVerbatim {
+			verbatim = !verbatim
+			lines[i] = "```"
+			continue
+		}
+		if verbatim {
+			continue
+		}
+		if match := zimHeadingRe.FindStringSubmatch(line); match != nil {
+			lines[i] = strings.Repeat("#", max(7-len(match[1]), 1)) + " " + zimInlineToMarkdown(match[2])
+			continue
+		}
+		if match := zimListRe.FindStringSubmatch(line); match != nil {
+			indent := strings.Repeat("  ", len(match[1]))
+			text := zimInlineToMarkdown(match[3])
+			switch bullet := match[2]; {
+			case bullet == "*":
+				lines[i] = indent + "- " + text
+			case bullet == "[x]":
+				// Cancelled, unlike [*] which is done
+				lines[i] = indent + "- [x] ~~" + text + "~~"
+			case strings.HasPrefix(bullet, "["):
+				lines[i] = indent + "- " + zimCheckboxes[bullet] + text
+			default:
+				lines[i] = indent + bullet + " " + text
+			}
+			continue
+		}
+		lines[i] = zimInlineToMarkdown(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func zimInlineToMarkdown(text string) string {
+	var out strings.Builder
+	last := 0
+	for from := 0; from < len(text); {
+		found := zimInlineRe.FindStringSubmatchIndex(text[from:])
+		if found == nil {
+			break
+		}
+		match := make([]int, len(found))
+		for i, index := range found {
+			match[i] = index
+			if index >= 0 {
+				match[i] += from
+			}
+		}
+		// Italics cannot start right after a colon, it is a URL
+		if match[10] >= 0 && match[0] > 0 && text[match[0]-1] == ':' {
+			from = match[0] + 2
+			continue
+		}
+		out.WriteString(text[last:match[0]])
+		last, from = match[1], match[1]
+
+		group := func(n int) (string, bool) {
+			if match[2*n] < 0 {
+				return "", false
+			}
+			return text[match[2*n]:match[2*n+1]], true
+		}
+		if code, ok := group(1); ok {
+			out.WriteString("`" + code + "`")
+		} else if target, ok := group(2); ok {
+			label, _ := group(3)
+			if strings.Contains(target, "://") || strings.HasPrefix(target, "mailto:") {
+				if label == "" {
+					label = target
+				}
+				out.WriteString("[" + label + "](" + target + ")")
+			} else if label != "" {
+				out.WriteString("[[" + target + "|" + label + "]]")
+			} else {
+				out.WriteString("[[" + target + "]]")
+			}
+		} else if inner, ok := group(4); ok {
+			out.WriteString("**" + zimInlineToMarkdown(inner) + "**")
+		} else if inner, ok := group(5); ok {
+			out.WriteString("*" + zimInlineToMarkdown(inner) + "*")
+		} else if inner, ok := group(6); ok {
+			out.WriteString("~~" + zimInlineToMarkdown(inner) + "~~")
+		} else if inner, ok := group(7); ok {
+			out.WriteString("==" + zimInlineToMarkdown(inner) + "==")
+		}
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+func markdownToZim(body string) string {
+	lines := strings.Split(body, "\n")
+	fenced := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+			lines[i] = zimVerbatim
+			continue
+		}
+		if fenced {
+			continue
+		}
+		if match := markdownHeadingRe.FindStringSubmatch(line); match != nil {
+			marks := strings.Repeat("=", max(7-len(match[1]), 2))
+			lines[i] = marks + " " + markdownToInline(match[2], zimSyntax) + " " + marks
+			continue
+		}
+		if item, ok := parseMarkdownListItem(line); ok {
+			indent := strings.Repeat("\t", item.depth)
+			text := item.text
+			bullet := "*"
+			switch {
+			case item.checkbox == 'x' && strings.HasPrefix(text, "~~") && strings.HasSuffix(text, "~~") &&
+				len(text) > 4:
+				bullet, text = "[x]", text[2:len(text)-2]
+			case item.checkbox == 'x':
+				bullet = "[*]"
+			case item.checkbox == ' ':
+				bullet = "[ ]"
+			case item.ordered:
+				bullet = strings.TrimRight(item.bullet, ".)") + "."
+			}
+			lines[i] = indent + bullet + " " + markdownToInline(text, zimSyntax)
+			continue
+		}
+		lines[i] = markdownToInline(line, zimSyntax)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderZimPage puts the headers, the heading and the converted body
+// together
+func renderZimPage(headers string, title string, body string) []byte {
+	text := headers + "\n\n" + "====== " + title + " ======\n"
+	if body != "" {
+		text += markdownToZim(body) + "\n"
+	}
+	return []byte(text)
+}
+
+func (self *ZimImplementation) PutData(note *types.Note) error {
+	note.Title = strings.TrimSpace(note.Title)
+	if note.Title == "" {
+		return errors.New("title cannot be empty")
+	}
+	relPath := zimFileName(note.Title) + zimExt
+	path := filepath.Join(self.path, relPath)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("\"%s\" already exists, cannot create new item", note.Title)
+	}
+
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = time.Now()
+	}
+	headers := zimContentType + "\nWiki-Format: zim 0.6\nCreation-Date: " +
+		note.CreatedAt.Format(zimTimeLayout)
+	body := note.CreatedAt.Format(zimCreatedLine) + "\n\n" + note.Body
+	if err := writeFileAtomic(path, renderZimPage(headers, note.Title, body), 0644); err != nil {
+		log.Println(err)
+		return err
+	}
+	return self.reload(relPath, note)
+}
+
+// UpdateData rewrites the page keeping its headers. A new title renames the
+// page file along with the directory of its subpages.
+func (self *ZimImplementation) UpdateData(oldNote *types.Note, newNote *types.Note) error {
+	relPath, ok := oldNote.AdditionalProperties[pathProperty]
+	if !ok {
+		return errors.New("note does not belong to the notebook")
+	}
+	path := filepath.Join(self.path, relPath)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !oldNote.ModifiedAt.IsZero() && !info.ModTime().Equal(oldNote.ModifiedAt) {
+		return fmt.Errorf("%w: \"%s\" was modified on disk", ErrConflict, oldNote.Title)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	newNote.Title = strings.TrimSpace(newNote.Title)
+	if newNote.Title == "" {
+		return errors.New("title cannot be empty")
+	}
+	newRelPath := relPath
+	if newNote.Title != oldNote.Title {
+		newRelPath = filepath.Join(filepath.Dir(relPath), zimFileName(newNote.Title)+zimExt)
+	}
+	newPath := filepath.Join(self.path, newRelPath)
+	if newPath != path {
+		if _, err := os.Stat(newPath); err == nil {
+			return fmt.Errorf("\"%s\" already exists, cannot rename", newNote.Title)
+		}
+	}
+
+	newContent := content
+	if newNote.Body != oldNote.Body || newNote.Title != oldNote.Title {
+		text := strings.ReplaceAll(string(content), "\r\n", "\n")
+		headers := zimContentType + "\nWiki-Format: zim 0.6"
+		if strings.HasPrefix(text, "Content-Type:") {
+			headers, text, _ = strings.Cut(text, "\n\n")
+		}
+		heading, rest := cutZimHeading(text)
+		if newNote.Body == oldNote.Body && heading != "" {
+			// Keep the text untouched when only the name changes
+			newContent = []byte(headers + "\n\n" + "====== " + newNote.Title + " ======\n" + rest)
+		} else {
+			newContent = renderZimPage(headers, newNote.Title, newNote.Body)
+		}
+	}
+
+	if err := writeFileAtomic(newPath, newContent, info.Mode().Perm()); err != nil {
+		log.Println(err)
+		return err
+	}
+	if newPath != path {
+		if err := os.Remove(path); err != nil {
+			log.Println(err)
+		}
+		oldDir := strings.TrimSuffix(path, zimExt)
+		if _, err := os.Stat(oldDir); err == nil {
+			if err := os.Rename(oldDir, strings.TrimSuffix(newPath, zimExt)); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	return self.reload(newRelPath, newNote)
+}
+
+func (self *ZimImplementation) reload(relPath string, note *types.Note) error {
+	reloaded, err := self.readNote(relPath)
+	if err != nil {
+		return err
+	}
+	copySaved(note, reloaded, 0)
+	return nil
+}
+
+func (self *ZimImplementation) DeleteData(note *types.Note) error {
+	relPath, ok := note.AdditionalProperties[pathProperty]
+	if !ok {
+		return errors.New("note does not belong to the notebook")
+	}
+	return os.Remove(filepath.Join(self.path, relPath))
+}
+
+// GetZimFiles returns directories of the notebooks Zim knows about by
+// notebook name
+func GetZimFiles() map[string]string {
+	files := make(map[string]string)
+
+	user, _ := user.Current()
+	seen := make(map[string]bool)
+	for _, listFile := range zimNotebookLists {
+		for _, dir := range readZimNotebookList(filepath.Join(user.HomeDir, listFile), user.HomeDir) {
+			resolved, err := filepath.EvalSymlinks(dir)
+			if err != nil || seen[resolved] {
+				continue
+			}
+			seen[resolved] = true
+
+			label := filepath.Base(dir)
+			if cfg, err := ini.Load(filepath.Join(dir, zimNotebookFile)); err == nil {
+				if name := cfg.Section("Notebook").Key("name").String(); name != "" {
+					label = name
+				}
+			}
+			name := fmt.Sprintf("Zim (%s)", label)
+			for i := 2; ; i++ {
+				if _, taken := files[name]; !taken {
+					break
+				}
+				name = fmt.Sprintf("Zim (%s %d)", label, i)
+			}
+			log.Println(name, dir)
+			files[name] = dir
+		}
+	}
+
+	return files
+}
+
+// readZimNotebookList returns notebook directories from both the current
+// format with a section per notebook and the old one listing URIs
+func readZimNotebookList(path string, homeDir string) []string {
+	cfg, err := ini.LoadSources(ini.LoadOptions{AllowBooleanKeys: true, KeyValueDelimiters: "="}, path)
+	if err != nil {
+		return nil
+	}
+
+	uris := make([]string, 0)
+	for _, section := range cfg.Sections() {
+		if section.Name() == "NotebookList" {
+			for _, key := range section.Keys() {
+				if key.Name() != "Default" {
+					uris = append(uris, key.Name())
+				}
+			}
+		} else if uri := section.Key("uri").String(); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+
+	dirs := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		dir := uri
+		if parsed, err := url.Parse(uri); err == nil && parsed.Scheme == "file" {
+			dir = parsed.Path
+		}
+		if strings.HasPrefix(dir, "~/") {
+			dir = filepath.Join(homeDir, dir[2:])
+		}
+		if _, err := os.Stat(filepath.Join(dir, zimNotebookFile)); err != nil {
+			continue
+		}
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
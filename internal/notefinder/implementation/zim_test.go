@@ -0,0 +1,36 @@
+package implementation
+
+import "testing"
+
+func TestZimMarkdownRoundTrip(t *testing.T) {
+	for _, body := range []string{
+		"plain text",
+		"**bold** and *italic* and ~~gone~~ and ==marked== and `code`",
+		"see [[Other:Page]] and [[Page|label]] and [site](https://example.org)",
+		"# Top\n## Heading\n### Smaller",
+		"- item\n  - nested\n- [ ] open\n- [x] done\n- [x] ~~cancelled~~\n1. first\n2. second",
+		"```\nverbatim **as is**\n```",
+		"https://example.org/path//x stays",
+	} {
+		text := markdownToZim(body)
+		if got := zimToMarkdown(text); got != body {
+			t.Errorf("round trip of %q\ngot  %q\nwant %q\nvia  %q", body, got, body, text)
+		}
+	}
+}
+
+func TestZimTextRoundTrip(t *testing.T) {
+	for _, text := range []string{
+		"Created Monday 01 January 2024",
+		"**bold** //italic// ~~strike~~ __marked__ ''code''",
+		"===== Plan =====\n==== Sub ====",
+		"* item\n\t* nested\n[ ] open\n[*] done\n[x] cancelled",
+		"'''\ncode //x//\n'''",
+		"see [[:Home]] [[https://example.org|site]] @tag",
+	} {
+		markdown := zimToMarkdown(text)
+		if got := markdownToZim(markdown); got != text {
+			t.Errorf("round trip of %q\ngot  %q\nvia  %q", text, got, markdown)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package search
+
+import (
+	"strings"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+// Expr is a node of a parsed query. Eval reports whether the note matches
+// and records names of the matching note fields in fields.
+type Expr interface {
+	Eval(note *types.Note, fields map[string]bool) bool
+}
+
+type And struct {
+	Left, Right Expr
+}
+
+type Or struct {
+	Left, Right Expr
+}
+
+type Not struct {
+	Expr Expr
+}
+
+// Term matches Value against Field, or against all searchable text of the
+// note if Field is empty.
+type Term struct {
+	Field     string
+	Value     string
+	MatchCase bool
+}
+
+type Flag struct {
+	Flag uint32
+}
+
+type TypeIs struct {
+	Type types.NoteType
+}
+
+type InNotebook struct {
+	Name string
+}
+
+// DateRange matches notes whose Field lies within [From, To). Zero bounds
+// are open.
+type DateRange struct {
+	Field    string
+	From, To time.Time
+}
+
+// Match evaluates expr against note and returns the matching fields
+func Match(expr Expr, note *types.Note) (bool, []string) {
+	fields := make(map[string]bool)
+	if !expr.Eval(note, fields) {
+		return false, nil
+	}
+
+	ret := make([]string, 0, len(fields))
+	for field := range fields {
+		ret = append(ret, field)
+	}
+	return true, ret
+}
+
+// Terms returns the words a matching note is expected to contain, for
+// ranking purposes
+func Terms(expr Expr) []string {
+	switch e := expr.(type) {
+	case *And:
+		return append(Terms(e.Left), Terms(e.Right)...)
+	case *Or:
+		return append(Terms(e.Left), Terms(e.Right)...)
+	case *Term:
+		return []string{e.Value}
+	}
+	return nil
+}
+
+func (self *And) Eval(note *types.Note, fields map[string]bool) bool {
+	matched := make(map[string]bool)
+	if !self.Left.Eval(note, matched) || !self.Right.Eval(note, matched) {
+		return false
+	}
+	for field := range matched {
+		fields[field] = true
+	}
+	return true
+}
+
+func (self *Or) Eval(note *types.Note, fields map[string]bool) bool {
+	left := self.Left.Eval(note, fields)
+	right := self.Right.Eval(note, fields)
+	return left || right
+}
+
+func (self *Not) Eval(note *types.Note, fields map[string]bool) bool {
+	return !self.Expr.Eval(note, make(map[string]bool))
+}
+
+func (self *Term) Eval(note *types.Note, fields map[string]bool) bool {
+	if self.Field == "" {
+		var found bool
+		for key, text := range note.SearchableText() {
+			if self.contains(text) {
+				fields[key] = true
+				found = true
+			}
+		}
+		return found
+	}
+
+	desc, ok := note.Mapping()[self.Field]
+	if !ok {
+		return false
+	}
+	var match bool
+	switch ptr := desc.Ptr.(type) {
+	case *string:
+		match = self.contains(*ptr)
+	case *[]string:
+		for _, tag := range *ptr {
+			if strings.EqualFold(tag, self.Value) {
+				match = true
+				break
+			}
+		}
+		if !match && strings.Contains(self.Value, "/") {
+			match = self.contains(strings.Join(*ptr, "/"))
+		}
+	}
+	if match {
+		fields[self.Field] = true
+	}
+	return match
+}
+
+func (self *Term) contains(value string) bool {
+	if self.MatchCase {
+		return strings.Contains(value, self.Value)
+	}
+	return strings.Contains(strings.ToLower(value), strings.ToLower(self.Value))
+}
+
+func (self *Flag) Eval(note *types.Note, fields map[string]bool) bool {
+	if !note.FlagIsSet(self.Flag) {
+		return false
+	}
+	fields["flags"] = true
+	return true
+}
+
+func (self *TypeIs) Eval(note *types.Note, fields map[string]bool) bool {
+	if note.Type != self.Type {
+		return false
+	}
+	fields["Type"] = true
+	return true
+}
+
+func (self *InNotebook) Eval(note *types.Note, fields map[string]bool) bool {
+	if note.Source == nil || !strings.EqualFold(note.Source.Name, self.Name) {
+		return false
+	}
+	fields["Notebook"] = true
+	return true
+}
+
+func (self *DateRange) Eval(note *types.Note, fields map[string]bool) bool {
+	ptr, ok := note.Mapping()[self.Field].Ptr.(*time.Time)
+	if !ok || ptr.IsZero() {
+		return false
+	}
+	if (!self.From.IsZero() && ptr.Before(self.From)) ||
+		(!self.To.IsZero() && !ptr.Before(self.To)) {
+		return false
+	}
+	fields[self.Field] = true
+	return true
+}
@@ -0,0 +1,406 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"notefinder/internal/notefinder/types"
+)
+
+/*
+Grammar:
+
+	expr    := and ("OR" and)*
+	and     := unary (["AND"] unary)*
+	unary   := ("NOT" | "-") unary | primary
+	primary := "(" expr ")" | [field ":"] (word | "quoted phrase")
+
+A "-" means NOT only right before a word starting with a letter, a quote or
+a parenthesis, hyphenated words and negative numbers are plain words.
+
+Known fields are title, body, tag, uri, mime, type, notebook, is, created
+and modified. Dates are YYYY, YYYY-MM or YYYY-MM-DD, optionally prefixed
+with <, <=, > or >=, or given as a FROM..TO range with either side optional.
+*/
+
+var (
+	textFields = map[string]string{
+		"title": "Title",
+		"body":  "Body",
+		"tag":   "Tags",
+		"uri":   "URI",
+		"mime":  "MimeType",
+	}
+	dateFields = map[string]string{
+		"created":  "CreatedAt",
+		"modified": "ModifiedAt",
+	}
+	flagNames = map[string]uint32{
+		"archived":  types.FlagArchived,
+		"readonly":  types.FlagReadOnly,
+		"notify":    types.FlagNotify,
+		"starred":   types.FlagStarred,
+		"encrypted": types.FlagEncrypted,
+	}
+	typeNames = map[string]types.NoteType{
+		"regular":  types.NoteTypeRegular,
+		"note":     types.NoteTypeRegular,
+		"bookmark": types.NoteTypeBookmark,
+		"voice":    types.NoteTypeVoice,
+		"file":     types.NoteTypeFile,
+		"todo":     types.NoteTypeTodoList,
+	}
+	dateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+)
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind  tokenKind
+	field string
+	value string
+}
+
+type parser struct {
+	tokens    []token
+	pos       int
+	matchCase bool
+}
+
+// Parse turns a query string into an expression tree
+func Parse(input string, matchCase bool) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	p := &parser{tokens: tokens, matchCase: matchCase}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected \"%s\"", p.tokens[p.pos].String())
+	}
+	return expr, nil
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenAnd:
+		return "AND"
+	case tokenOr:
+		return "OR"
+	case tokenNot:
+		return "NOT"
+	case tokenOpen:
+		return "("
+	case tokenClose:
+		return ")"
+	}
+	if t.field != "" {
+		return t.field + ":" + t.value
+	}
+	return t.value
+}
+
+func lex(input string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case r == ' ' || r == '\t' || r == '\n':
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpen})
+			i++
+			continue
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenClose})
+			i++
+			continue
+		case r == '-' && negates(runes[i+1:]):
+			tokens = append(tokens, token{kind: tokenNot})
+			i++
+			continue
+		}
+
+		var chunk strings.Builder
+		quoteAt := -1
+		for ; i < len(runes); i++ {
+			r := runes[i]
+			if r == '"' {
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				if end == len(runes) {
+					return nil, fmt.Errorf("unterminated quote")
+				}
+				if quoteAt < 0 {
+					quoteAt = chunk.Len()
+				}
+				chunk.WriteString(string(runes[i+1 : end]))
+				i = end
+				continue
+			}
+			if r == ' ' || r == '\t' || r == '\n' || r == '(' || r == ')' {
+				break
+			}
+			chunk.WriteRune(r)
+		}
+
+		word := chunk.String()
+		if quoteAt < 0 {
+			switch word {
+			case "AND":
+				tokens = append(tokens, token{kind: tokenAnd})
+				continue
+			case "OR":
+				tokens = append(tokens, token{kind: tokenOr})
+				continue
+			case "NOT":
+				tokens = append(tokens, token{kind: tokenNot})
+				continue
+			}
+		}
+
+		tok := token{kind: tokenWord, value: word}
+		colon := strings.Index(word, ":")
+		if field, value, ok := strings.Cut(word, ":"); ok && isField(field) &&
+			(quoteAt < 0 || colon < quoteAt) {
+			tok.field = strings.ToLower(field)
+			tok.value = value
+		}
+		tokens = append(tokens, tok)
+	}
+
+	return tokens, nil
+}
+
+// negates tells whether "-" right before rest means NOT, a hyphen in a
+// field value does not count
+func negates(rest []rune) bool {
+	if len(rest) == 0 {
+		return false
+	}
+	switch r := rest[0]; {
+	case r == '(' || r == '"':
+		return true
+	case !unicode.IsLetter(r):
+		return false
+	}
+	for _, r := range rest {
+		if r == ' ' || r == '\t' || r == '\n' || r == '(' || r == ')' || r == '"' || r == ':' {
+			break
+		}
+		if r == '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isField(name string) bool {
+	name = strings.ToLower(name)
+	_, isText := textFields[name]
+	_, isDate := dateFields[name]
+	return isText || isDate || name == "type" || name == "notebook" || name == "is"
+}
+
+func (p *parser) peek() *token {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok != nil && tok.kind == tokenOr; tok = p.peek() {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok != nil && tok.kind != tokenOr && tok.kind != tokenClose; tok = p.peek() {
+		if tok.kind == tokenAnd {
+			p.pos++
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	tok := p.peek()
+	if tok == nil {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+	if tok.kind == tokenNot {
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+	if tok == nil {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+	p.pos++
+
+	switch tok.kind {
+	case tokenOpen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if next := p.peek(); next == nil || next.kind != tokenClose {
+			return nil, fmt.Errorf("missing \")\"")
+		}
+		p.pos++
+		return expr, nil
+	case tokenWord:
+		return p.term(tok)
+	}
+	return nil, fmt.Errorf("unexpected \"%s\"", tok.String())
+}
+
+func (p *parser) term(tok *token) (Expr, error) {
+	if tok.field == "" {
+		return &Term{Value: tok.value, MatchCase: p.matchCase}, nil
+	}
+	if tok.value == "" {
+		return nil, fmt.Errorf("missing value for \"%s:\"", tok.field)
+	}
+
+	if field, ok := textFields[tok.field]; ok {
+		return &Term{Field: field, Value: tok.value, MatchCase: p.matchCase}, nil
+	}
+	if field, ok := dateFields[tok.field]; ok {
+		return parseDateRange(field, tok.value)
+	}
+
+	switch tok.field {
+	case "type":
+		if type_, ok := typeNames[strings.ToLower(tok.value)]; ok {
+			return &TypeIs{Type: type_}, nil
+		}
+		return nil, fmt.Errorf("unknown note type \"%s\"", tok.value)
+	case "notebook":
+		return &InNotebook{Name: tok.value}, nil
+	case "is":
+		if flag, ok := flagNames[strings.ToLower(tok.value)]; ok {
+			return &Flag{Flag: flag}, nil
+		}
+		return nil, fmt.Errorf("unknown flag \"%s\"", tok.value)
+	}
+	return nil, fmt.Errorf("unknown field \"%s\"", tok.field)
+}
+
+func parseDateRange(field string, value string) (Expr, error) {
+	ret := &DateRange{Field: field}
+
+	if from, to, ok := strings.Cut(value, ".."); ok {
+		if from != "" {
+			start, _, err := parseDate(from)
+			if err != nil {
+				return nil, err
+			}
+			ret.From = start
+		}
+		if to != "" {
+			_, end, err := parseDate(to)
+			if err != nil {
+				return nil, err
+			}
+			ret.To = end
+		}
+		return ret, nil
+	}
+
+	var op string
+	for _, prefix := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(value, prefix) {
+			op = prefix
+			value = value[len(prefix):]
+			break
+		}
+	}
+	start, end, err := parseDate(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case ">=":
+		ret.From = start
+	case ">":
+		ret.From = end
+	case "<=":
+		ret.To = end
+	case "<":
+		ret.To = start
+	default:
+		ret.From, ret.To = start, end
+	}
+	return ret, nil
+}
+
+// parseDate returns the period covered by a (possibly partial) date
+func parseDate(value string) (time.Time, time.Time, error) {
+	for _, layout := range dateLayouts {
+		start, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+		switch layout {
+		case "2006":
+			return start, start.AddDate(1, 0, 0), nil
+		case "2006-01":
+			return start, start.AddDate(0, 1, 0), nil
+		default:
+			return start, start.AddDate(0, 0, 1), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date \"%s\"", value)
+}
@@ -0,0 +1,183 @@
+package search
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"notefinder/internal/notefinder/types"
+)
+
+func day(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+func TestParse(t *testing.T) {
+	term := func(value string) *Term { return &Term{Value: value} }
+
+	tests := []struct {
+		input string
+		want  Expr
+	}{
+		{"foo", term("foo")},
+		{"foo bar", &And{Left: term("foo"), Right: term("bar")}},
+		{"foo AND bar", &And{Left: term("foo"), Right: term("bar")}},
+		{"foo OR bar baz", &Or{Left: term("foo"),
+			Right: &And{Left: term("bar"), Right: term("baz")}}},
+		{"foo bar OR baz", &Or{Left: &And{Left: term("foo"), Right: term("bar")},
+			Right: term("baz")}},
+		{"(foo OR bar) baz", &And{Left: &Or{Left: term("foo"), Right: term("bar")},
+			Right: term("baz")}},
+		{"NOT foo bar", &And{Left: &Not{Expr: term("foo")}, Right: term("bar")}},
+		{"NOT NOT foo", &Not{Expr: &Not{Expr: term("foo")}}},
+		{"-foo", &Not{Expr: term("foo")}},
+		{"-(foo OR bar)", &Not{Expr: &Or{Left: term("foo"), Right: term("bar")}}},
+		{`-"foo bar"`, &Not{Expr: term("foo bar")}},
+		{"-tag:foo-bar", &Not{Expr: &Term{Field: "Tags", Value: "foo-bar"}}},
+		{"-fno-strict", term("-fno-strict")},
+		{"-5", term("-5")},
+		{"foo -", &And{Left: term("foo"), Right: term("-")}},
+		{"pre-commit", term("pre-commit")},
+		{`"foo bar"`, term("foo bar")},
+		{`"OR"`, term("OR")},
+		{`title:"foo bar"`, &Term{Field: "Title", Value: "foo bar"}},
+		{`"title:foo"`, term("title:foo")},
+		{"Title:foo", &Term{Field: "Title", Value: "foo"}},
+		{"http://example.com", term("http://example.com")},
+		{"type:todo", &TypeIs{Type: types.NoteTypeTodoList}},
+		{"is:starred", &Flag{Flag: types.FlagStarred}},
+		{"notebook:Work", &InNotebook{Name: "Work"}},
+		{"created:2024", &DateRange{Field: "CreatedAt",
+			From: day(2024, 1, 1), To: day(2025, 1, 1)}},
+		{"created:2024-02", &DateRange{Field: "CreatedAt",
+			From: day(2024, 2, 1), To: day(2024, 3, 1)}},
+		{"modified:2024-02-29", &DateRange{Field: "ModifiedAt",
+			From: day(2024, 2, 29), To: day(2024, 3, 1)}},
+		{"created:2024-01..2024-03", &DateRange{Field: "CreatedAt",
+			From: day(2024, 1, 1), To: day(2024, 4, 1)}},
+		{"created:2024..", &DateRange{Field: "CreatedAt", From: day(2024, 1, 1)}},
+		{"created:..2024", &DateRange{Field: "CreatedAt", To: day(2025, 1, 1)}},
+		{"created:>=2024-05", &DateRange{Field: "CreatedAt", From: day(2024, 5, 1)}},
+		{"created:>2024-05", &DateRange{Field: "CreatedAt", From: day(2024, 6, 1)}},
+		{"created:<=2024-05", &DateRange{Field: "CreatedAt", To: day(2024, 6, 1)}},
+		{"created:<2024-05", &DateRange{Field: "CreatedAt", To: day(2024, 5, 1)}},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.input, false)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"(foo",
+		"foo)",
+		"(foo OR bar",
+		"()",
+		"foo OR",
+		"OR foo",
+		"foo AND",
+		"NOT",
+		`"foo`,
+		"title:",
+		"type:unknown",
+		"is:unknown",
+		"created:yesterday",
+		"created:2024-13",
+		"created:2024-02-30",
+		"created:>=",
+		"created:2024..soon",
+		"created:later..2024",
+	}
+
+	for _, input := range tests {
+		if expr, err := Parse(input, false); err == nil {
+			t.Errorf("Parse(%q) = %#v, want an error", input, expr)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	note := types.NewNote(1, "Shopping list")
+	note.Set("Body", "Buy Milk and bread", true)
+	note.Tags = []string{"home", "errands"}
+	note.URI = "https://example.com/list"
+	note.Type = types.NoteTypeTodoList
+	note.CreatedAt = time.Date(2024, 5, 31, 23, 59, 0, 0, time.Local)
+	note.ModifiedAt = day(2024, 6, 1)
+	note.Source = &types.Notebook{Name: "Personal"}
+	note.SetFlag(types.FlagStarred)
+
+	tests := []struct {
+		input     string
+		matchCase bool
+		match     bool
+		fields    []string
+	}{
+		{"milk", false, true, []string{"Body"}},
+		{"milk", true, false, nil},
+		{"Milk", true, true, []string{"Body"}},
+		{"shopping milk", false, true, []string{"Body", "Title"}},
+		{"shopping cheese", false, false, nil},
+		{"cheese OR milk", false, true, []string{"Body"}},
+		{"list", false, true, []string{"Title"}},
+		{"-milk", false, false, nil},
+		{"-cheese", false, true, []string{}},
+		{"shopping -cheese", false, true, []string{"Title"}},
+		{"NOT (milk OR cheese)", false, false, nil},
+		{`"milk and bread"`, false, true, []string{"Body"}},
+		{`"bread and milk"`, false, false, nil},
+		{"title:milk", false, false, nil},
+		{"body:milk", false, true, []string{"Body"}},
+		{"tag:home", false, true, []string{"Tags"}},
+		{"tag:hom", false, false, nil},
+		{"tag:home/errands", false, true, []string{"Tags"}},
+		{"uri:example.com", false, true, []string{"URI"}},
+		{"type:todo", false, true, []string{"Type"}},
+		{"type:bookmark", false, false, nil},
+		{"notebook:personal", false, true, []string{"Notebook"}},
+		{"is:starred", false, true, []string{"flags"}},
+		{"is:archived", false, false, nil},
+		{"created:2024-05-31", false, true, []string{"CreatedAt"}},
+		{"created:2024-06", false, false, nil},
+		{"created:..2024-05-31", false, true, []string{"CreatedAt"}},
+		{"created:2024-06..", false, false, nil},
+		{"created:<=2024-05-31", false, true, []string{"CreatedAt"}},
+		{"created:<2024-05-31", false, false, nil},
+		{"created:>=2024-05-31", false, true, []string{"CreatedAt"}},
+		{"created:>2024-05-31", false, false, nil},
+		{"created:>2024-05-30", false, true, []string{"CreatedAt"}},
+		// Ranges include the start of the period and end before the next
+		{"modified:2024-06-01", false, true, []string{"ModifiedAt"}},
+		{"modified:<2024-06-01", false, false, nil},
+		{"modified:>=2024-06", false, true, []string{"ModifiedAt"}},
+		{"modified:2024-05", false, false, nil},
+		{"modified:2024-05..2024-05", false, false, nil},
+		{"modified:2024-05..2024-06-01", false, true, []string{"ModifiedAt"}},
+		{"-fno-strict", false, false, nil},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input, test.matchCase)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.input, err)
+			continue
+		}
+		match, fields := Match(expr, note)
+		slices.Sort(fields)
+		if match != test.match || !slices.Equal(fields, test.fields) {
+			t.Errorf("Match(%q) = %v %q, want %v %q", test.input, match, fields,
+				test.match, test.fields)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package types
 
 import (
-	_ "golang.org/x/crypto/chacha20poly1305"
+	"maps"
+	"slices"
+	"strings"
 	"time"
+
+	_ "golang.org/x/crypto/chacha20poly1305"
 )
 
 const (
@@ -32,6 +36,13 @@ const (
 	NoteTypeTodoList
 )
 
+// Additional properties of tasks which are understood across notebooks.
+// Completed holds the completion date, if known.
+const (
+	PropertyCompleted = "Completed"
+	PropertyPriority  = "Priority"
+)
+
 type Note struct {
 	Source               *Notebook
 	UUID                 uint64
@@ -39,6 +50,7 @@ type Note struct {
 	Body                 string
 	Tags                 []string
 	URI                  string
+	Annotation           string
 	MimeType             string
 	CreatedAt            time.Time
 	ModifiedAt           time.Time
@@ -47,6 +59,7 @@ type Note struct {
 	Markup               Markup
 	LastMatchingQuery    *Query
 	MatchingFields       []string
+	Score                float64
 	AdditionalProperties map[string]string
 }
 
@@ -64,9 +77,19 @@ func (this *Note) SameAs(other *Note) bool {
 		this.Title == other.Title &&
 		this.Body == other.Body &&
 		this.URI == other.URI &&
+		this.Annotation == other.Annotation &&
+		slices.Equal(this.Tags, other.Tags) &&
 		this.flags == other.flags)
 }
 
+func (n *Note) Clone() *Note {
+	clone := *n
+	clone.Tags = slices.Clone(n.Tags)
+	clone.MatchingFields = make([]string, 0, 4)
+	clone.AdditionalProperties = maps.Clone(n.AdditionalProperties)
+	return &clone
+}
+
 func (n *Note) SetFlag(flag uint32) {
 	n.flags |= flag
 }
@@ -79,6 +102,10 @@ func (n *Note) FlagIsSet(flag uint32) bool {
 	return n.flags&flag != 0
 }
 
+func (n *Note) Flags() uint32 {
+	return n.flags
+}
+
 func (n *Note) FlagsString() string {
 	var out [32]rune
 	for i := 31; i >= 0; i-- {
@@ -124,12 +151,36 @@ type FieldDescription struct {
 
 func (self *Note) Mapping() map[string]*FieldDescription {
 	return map[string]*FieldDescription{
-		"UUID":     &FieldDescription{Ptr: &self.UUID},
-		"Title":    &FieldDescription{Ptr: &self.Title, Searchable: true},
-		"Body":     &FieldDescription{Ptr: &self.Body, Searchable: true},
-		"URI":      &FieldDescription{Ptr: &self.URI},
-		"MimeType": &FieldDescription{Ptr: &self.MimeType},
-		"Type":     &FieldDescription{Ptr: &self.Type},
-		"flags":    &FieldDescription{Ptr: &self.flags},
+		"UUID":       &FieldDescription{Ptr: &self.UUID},
+		"Title":      &FieldDescription{Ptr: &self.Title, Searchable: true},
+		"Body":       &FieldDescription{Ptr: &self.Body, Searchable: true},
+		"Tags":       &FieldDescription{Ptr: &self.Tags},
+		"URI":        &FieldDescription{Ptr: &self.URI},
+		"Annotation": &FieldDescription{Ptr: &self.Annotation, Searchable: true},
+		"MimeType":   &FieldDescription{Ptr: &self.MimeType},
+		"Type":       &FieldDescription{Ptr: &self.Type},
+		"CreatedAt":  &FieldDescription{Ptr: &self.CreatedAt},
+		"ModifiedAt": &FieldDescription{Ptr: &self.ModifiedAt},
+		"flags":      &FieldDescription{Ptr: &self.flags},
+	}
+}
+
+// SearchableText returns texts to look for words in by field name: the
+// searchable fields, tags and additional properties
+func (self *Note) SearchableText() map[string]string {
+	ret := make(map[string]string)
+	for key, desc := range self.Mapping() {
+		if desc.Searchable {
+			ret[key] = *desc.Ptr.(*string)
+		}
+	}
+	if len(self.Tags) > 0 {
+		ret["Tags"] = strings.Join(self.Tags, " ")
+	}
+	for key, value := range self.AdditionalProperties {
+		if _, ok := ret[key]; !ok {
+			ret[key] = value
+		}
 	}
+	return ret
 }
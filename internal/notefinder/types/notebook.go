@@ -10,6 +10,44 @@ type Implementation interface {
 	CanWrite() (bool, error)
 }
 
+// ChangeSet describes what happened to notebook contents since some point
+type ChangeSet struct {
+	Added   map[uint64]*Note
+	Changed map[uint64]*Note
+	Deleted []uint64
+	// Reload is set if the changes cannot be told precisely and the whole
+	// notebook should be loaded again
+	Reload bool
+	// Snapshot is set if Added holds complete notebook contents, so anything
+	// else is gone
+	Snapshot bool
+}
+
+func NewChangeSet() *ChangeSet {
+	return &ChangeSet{Added: make(map[uint64]*Note),
+		Changed: make(map[uint64]*Note), Deleted: make([]uint64, 0)}
+}
+
+// Watcher is implemented by notebooks which can report changes as they
+// happen, so they do not have to be polled. Watch blocks until stop is
+// closed.
+type Watcher interface {
+	Watch(changes chan<- *ChangeSet, stop <-chan struct{}) error
+}
+
+// IncrementalLoader is implemented by notebooks which can load only what
+// changed since the cursor returned by the previous call. The cursor is
+// opaque to the caller, the first call gets an empty one.
+type IncrementalLoader interface {
+	LoadChanges(cursor string) (*ChangeSet, string, error)
+}
+
+// IconLoader is implemented by notebooks which have pictures for their
+// notes, e.g. favicons. LoadIcon returns nil if there is none.
+type IconLoader interface {
+	LoadIcon(note *Note) ([]byte, error)
+}
+
 type NotebookType int
 
 const (
@@ -24,6 +62,7 @@ type Notebook struct {
 	implementation Implementation
 	Type           NotebookType
 	Enabled        bool
+	cursor         string
 }
 
 func NewNotebook(name string, impl Implementation, config map[string]string,
@@ -37,10 +76,30 @@ func (self *Notebook) LoadData() (map[uint64]*Note, error) {
 	return data, err
 }
 
+// LoadChanges loads what changed since the previous call, ok is false if the
+// implementation can only load everything at once
+func (self *Notebook) LoadChanges() (changes *ChangeSet, ok bool, err error) {
+	loader, ok := self.implementation.(IncrementalLoader)
+	if !ok {
+		return nil, false, nil
+	}
+
+	changes, cursor, err := loader.LoadChanges(self.cursor)
+	if err != nil {
+		return nil, true, err
+	}
+	self.cursor = cursor
+	return changes, true, nil
+}
+
 func (self *Notebook) CanWrite() (bool, error) {
 	return self.implementation.CanWrite()
 }
 
+func (self *Notebook) SupportedProperties() map[string]Writable {
+	return self.implementation.SupportedProperties()
+}
+
 func (self *Notebook) PutData(note *Note) error {
 	return self.implementation.PutData(note)
 }
@@ -52,3 +111,26 @@ func (self *Notebook) UpdateData(oldNote *Note, newNote *Note) error {
 func (self *Notebook) DeleteData(note *Note) error {
 	return self.implementation.DeleteData(note)
 }
+
+// Watcher returns the notebook implementation if it supports watching
+func (self *Notebook) Watcher() (Watcher, bool) {
+	watcher, ok := self.implementation.(Watcher)
+	return watcher, ok
+}
+
+// HasIcons tells whether the implementation provides pictures for notes
+func (self *Notebook) HasIcons() bool {
+	_, ok := self.implementation.(IconLoader)
+	return ok
+}
+
+// LoadIcon loads the picture for the note, ok is false if the implementation
+// has no pictures at all
+func (self *Notebook) LoadIcon(note *Note) (data []byte, ok bool, err error) {
+	loader, ok := self.implementation.(IconLoader)
+	if !ok {
+		return nil, false, nil
+	}
+	data, err = loader.LoadIcon(note)
+	return data, true, err
+}
@@ -15,6 +15,9 @@ import (
 	"notefinder/internal/notefinder/util"
 )
 
+// Read-only notes can only be tagged, starred and annotated
+var errReadOnly = errors.New("the note is read-only, its title and text cannot be changed")
+
 type EditorTabItem struct {
 	note    *types.Note
 	tabItem *container.TabItem
@@ -43,6 +46,10 @@ func NewEditorTabItem(note *types.Note, parent *Window) *EditorTabItem {
 			theme.DocumentCreateIcon(),
 			func() {
 				if ti.viewer.Visible() {
+					if ti.note.FlagIsSet(types.FlagReadOnly) {
+						dialog.ShowError(errReadOnly, parent)
+						return
+					}
 					ti.viewer.Hide()
 					ti.editor.Show()
 				} else {
@@ -53,6 +60,10 @@ func NewEditorTabItem(note *types.Note, parent *Window) *EditorTabItem {
 		),
 		widget.NewToolbarAction(theme.DocumentSaveIcon(),
 			func() {
+				if note.FlagIsSet(types.FlagReadOnly) {
+					dialog.ShowError(errReadOnly, parent)
+					return
+				}
 				entry := widget.NewEntry()
 				var proposedTitle string
 				if note.Title == "" && ti.editor.Text != "" {
@@ -72,6 +83,22 @@ func NewEditorTabItem(note *types.Note, parent *Window) *EditorTabItem {
 						if !ok || entry.Text == "" {
 							return
 						}
+						if note.Source != nil && note.UUID != 0 {
+							newNote := note.Clone()
+							newNote.Title = entry.Text
+							newNote.Set("Body", ti.editor.Text, true)
+							if err := parent.store.UpdateNote(note, newNote); err != nil {
+								dialog.ShowError(err, parent)
+								return
+							}
+							note = newNote
+							ti.note = newNote
+							ti.viewer.ParseMarkdown(note.Body)
+							parent.tabs.Selected().Text = note.Title
+							parent.tabs.Refresh()
+							ti.parent.RequestRefresh()
+							return
+						}
 						note.Title = entry.Text
 						parent.tabs.Selected().Text = note.Title
 						parent.tabs.Refresh()
@@ -98,9 +125,15 @@ func NewEditorTabItem(note *types.Note, parent *Window) *EditorTabItem {
 		),
 	)
 
+	var info fyne.CanvasObject
+	if created, modified := formatTime(note.CreatedAt), formatTime(note.ModifiedAt); modified != "" {
+		info = widget.NewLabelWithStyle("Created: "+created+"    Modified: "+modified,
+			fyne.TextAlignLeading, fyne.TextStyle{Italic: true})
+	}
+
 	togglableView := container.New(layout.NewStackLayout(), ti.viewer, ti.editor)
-	tabContent := container.NewBorder(tb, nil, nil, nil, togglableView)
-	ti.tabItem = container.NewTabItemWithIcon(note.Title, noteIcon(note), tabContent)
+	tabContent := container.NewBorder(tb, info, nil, nil, togglableView)
+	ti.tabItem = container.NewTabItemWithIcon(note.Title, parent.noteIcon(note), tabContent)
 	/*
 		parent.tabs.Append(tabItem)
 		parent.tabs.Select(tabItem)
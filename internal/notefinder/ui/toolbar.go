@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/url"
 	"strings"
+	"time"
 
 	fyne "fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
@@ -55,6 +56,12 @@ func makeToolbar(win *Window) *widget.Toolbar {
 		}),
 		widget.NewToolbarAction(theme.MediaRecordIcon(), func() {}),
 		widget.NewToolbarAction(theme.VisibilityOffIcon(), func() {}),
+		widget.NewToolbarAction(theme.MoreVerticalIcon(), func() {
+			if win.selectedNote == nil {
+				return
+			}
+			showPropertiesDialog(win, win.selectedNote)
+		}),
 		widget.NewToolbarAction(theme.DeleteIcon(), func() {
 			if win.selectedNote == nil {
 				return
@@ -148,3 +155,86 @@ func makeToolbar(win *Window) *widget.Toolbar {
 		}),
 	)
 }
+
+func showPropertiesDialog(win *Window, note *types.Note) {
+	starred := widget.NewCheck("", nil)
+	starred.SetChecked(note.FlagIsSet(types.FlagStarred))
+	archived := widget.NewCheck("", nil)
+	archived.SetChecked(note.FlagIsSet(types.FlagArchived))
+	tags := widget.NewEntry()
+	tags.SetText(strings.Join(note.Tags, ", "))
+	annotation := widget.NewMultiLineEntry()
+	annotation.SetText(note.Annotation)
+	done := widget.NewCheck("", nil)
+	_, isDone := note.AdditionalProperties[types.PropertyCompleted]
+	done.SetChecked(isDone)
+	priority := widget.NewEntry()
+	priority.SetText(note.AdditionalProperties[types.PropertyPriority])
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Starred", starred),
+		widget.NewFormItem("Archived", archived),
+		widget.NewFormItem("Tags", tags),
+		widget.NewFormItem("Annotation", annotation),
+	}
+	// Only notebooks which know about tasks can store these
+	isTask := note.Type == types.NoteTypeTodoList && note.Source != nil &&
+		bool(note.Source.SupportedProperties()[types.PropertyCompleted])
+	if isTask {
+		items = append(items, widget.NewFormItem("Done", done),
+			widget.NewFormItem("Priority", priority))
+	}
+
+	form := dialog.NewForm(note.Title, "Save", "Cancel", items,
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			newNote := note.Clone()
+			for flag, check := range map[uint32]*widget.Check{
+				types.FlagStarred:  starred,
+				types.FlagArchived: archived,
+			} {
+				if check.Checked {
+					newNote.SetFlag(flag)
+				} else {
+					newNote.UnsetFlag(flag)
+				}
+			}
+			newNote.Tags = make([]string, 0)
+			for _, tag := range strings.Split(tags.Text, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					newNote.Tags = append(newNote.Tags, tag)
+				}
+			}
+			newNote.Annotation = annotation.Text
+			if isTask {
+				setTaskProperties(newNote, done.Checked, strings.TrimSpace(priority.Text))
+			}
+
+			if err := win.store.UpdateNote(note, newNote); err != nil {
+				dialog.ShowError(err, win)
+				return
+			}
+			win.RequestRefresh()
+		}, win)
+	form.Resize(fyne.NewSize(400, 300))
+	form.Show()
+}
+
+// setTaskProperties keeps the completion date of tasks which stay done
+func setTaskProperties(note *types.Note, done bool, priority string) {
+	if note.AdditionalProperties == nil {
+		note.AdditionalProperties = make(map[string]string)
+	}
+	if _, wasDone := note.AdditionalProperties[types.PropertyCompleted]; done && !wasDone {
+		note.AdditionalProperties[types.PropertyCompleted] = time.Now().Format("2006-01-02")
+	} else if !done {
+		delete(note.AdditionalProperties, types.PropertyCompleted)
+	}
+	if priority != "" {
+		note.AdditionalProperties[types.PropertyPriority] = strings.ToUpper(priority)
+	} else {
+		delete(note.AdditionalProperties, types.PropertyPriority)
+	}
+}
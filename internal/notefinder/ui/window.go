@@ -1,10 +1,13 @@
 package ui
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"net/url"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -29,8 +32,10 @@ var uriError = errors.New("Unsupported URI")
 
 type Store interface {
 	GetNotebooks() map[string]*types.Notebook
-	QueryStream(query *types.Query, out chan<- *types.Note)
+	QueryStream(query *types.Query, out chan<- *types.Note) error
 	Query(query *types.Query) []*types.Note
+	UpdateNote(oldNote *types.Note, newNote *types.Note) error
+	NoteIcon(note *types.Note, loaded func()) []byte
 }
 
 type Context interface {
@@ -59,6 +64,8 @@ type Window struct {
 	selectedListID   int
 	filterByNotebook bool
 	matchCase        bool
+	method           types.QueryMethod
+	sortOrder        string
 	listItemIDToNote map[widget.ListItemID]*types.Note
 }
 
@@ -102,12 +109,19 @@ func (w *Window) Refresh() {
 		w.query.Haystack = nil
 	}
 	w.query.MatchCase = w.matchCase
+	w.query.Method = w.method
 
 	ch := make(chan *types.Note)
 	var mu sync.Mutex
 	var notes []*types.Note
 
-	go w.store.QueryStream(w.query, ch)
+	go func(query *types.Query) {
+		if err := w.store.QueryStream(query, ch); err != nil {
+			fyne.Do(func() {
+				w.statusBar.SetText(err.Error())
+			})
+		}
+	}(w.query)
 
 	nResults := 0
 	go func() {
@@ -115,8 +129,9 @@ func (w *Window) Refresh() {
 			nResults++
 			n := note
 			mu.Lock()
-			notes = append(notes, n)
-			sort.Slice(notes, func(i, j int) bool { return notes[i].UUID < notes[j].UUID })
+			// After the equal ones, so the order of arrival breaks ties
+			at := sort.Search(len(notes), func(i int) bool { return w.less(n, notes[i]) })
+			notes = slices.Insert(notes, at, n)
 			mu.Unlock()
 			status := fmt.Sprintf("%d results", nResults)
 			fyne.Do(func() {
@@ -151,7 +166,7 @@ func (w *Window) Refresh() {
 		note := notes[i]
 
 		title.TextStyle.Bold = (i == w.selectedListID)
-		icon.SetResource(noteIcon(note))
+		icon.SetResource(w.noteIcon(note))
 		fyne.Do(func() {
 			title.SetText(note.Title)
 		})
@@ -170,6 +185,9 @@ func (w *Window) Refresh() {
 			}
 		}
 
+		if date := formatTime(note.ModifiedAt); date != "" {
+			detail.Text = date + "  " + detail.Text
+		}
 		if w.query.Needle != "" {
 			detail.Text += matchesText
 		}
@@ -184,6 +202,26 @@ func (w *Window) Refresh() {
 	})
 }
 
+func (w *Window) less(a *types.Note, b *types.Note) bool {
+	switch w.sortOrder {
+	case "Modified":
+		return a.ModifiedAt.After(b.ModifiedAt)
+	case "Created":
+		return a.CreatedAt.After(b.CreatedAt)
+	case "Title":
+		return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+	default:
+		return a.Score > b.Score
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
 func (w *Window) ClipboardContent() string {
 	return w.Clipboard().Content()
 }
@@ -275,6 +313,25 @@ func (w *Window) makeLayout() *fyne.Container {
 		}
 	})
 	selector.PlaceHolder = "Current working notebook"
+
+	methods := map[string]types.QueryMethod{
+		"Words":  types.QueryWithIndex,
+		"Regexp": types.QueryRegexp,
+		"Query":  types.QueryComplex,
+	}
+	methodSelector := widget.NewSelect([]string{"Words", "Regexp", "Query"},
+		func(value string) {
+			w.method = methods[value]
+			w.Refresh()
+		})
+	methodSelector.Selected = "Words"
+
+	sortSelector := widget.NewSelect([]string{"Relevance", "Modified", "Created", "Title"},
+		func(value string) {
+			w.sortOrder = value
+			w.Refresh()
+		})
+	sortSelector.Selected = "Relevance"
 	notebookSelector := container.New(layout.NewHBoxLayout(),
 		w.statusBar,
 		selector,
@@ -288,6 +345,8 @@ func (w *Window) makeLayout() *fyne.Container {
 			w.matchCase = value
 			w.Refresh()
 		}),
+		methodSelector,
+		sortSelector,
 	)
 
 	w.list = makeList(w)
@@ -332,7 +391,37 @@ func (w *Window) makeSearchInput() *widget.Entry {
 	return input
 }
 
-func noteIcon(note *types.Note) fyne.Resource {
+// noteIcon returns the picture of the note, e.g. a favicon, falling back
+// to the icon of its type
+func (w *Window) noteIcon(note *types.Note) fyne.Resource {
+	data := w.store.NoteIcon(note, func() {
+		fyne.Do(func() {
+			w.list.Refresh()
+		})
+	})
+	if len(data) == 0 {
+		return typeIcon(note)
+	}
+
+	// Fyne caches images by resource name and guesses the format from it
+	var ext string
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG")):
+		ext = ".png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		ext = ".jpg"
+	case bytes.Contains(data[:min(len(data), 512)], []byte("<svg")):
+		ext = ".svg"
+	default:
+		// E.g. .ico, which Fyne cannot decode
+		return typeIcon(note)
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(note.URI))
+	return fyne.NewStaticResource(fmt.Sprintf("icon-%x%s", hash.Sum64(), ext), data)
+}
+
+func typeIcon(note *types.Note) fyne.Resource {
 	switch note.Type {
 	case types.NoteTypeBookmark:
 		return theme.HistoryIcon()
@@ -7,19 +7,53 @@ package util
 import "C"
 
 import (
+	"regexp"
 	"strings"
 	"unsafe"
 )
 
 func PdfMatchesPattern(uri string, pattern string) bool {
+	var found bool
+	pdfPages(uri, func(pageText string) bool {
+		found = strings.Contains(
+			strings.ToLower(pageText),
+			strings.ToLower(pattern))
+		return !found
+	})
+
+	return found
+}
+
+func PdfMatchesRegexp(uri string, re *regexp.Regexp) bool {
+	var found bool
+	pdfPages(uri, func(pageText string) bool {
+		found = re.MatchString(pageText)
+		return !found
+	})
+
+	return found
+}
+
+func PdfText(uri string) string {
+	var text strings.Builder
+	pdfPages(uri, func(pageText string) bool {
+		text.WriteString(pageText)
+		text.WriteString("\n")
+		return true
+	})
+
+	return text.String()
+}
+
+func pdfPages(uri string, fn func(pageText string) bool) {
 	cUri := C.CString(uri)
 	defer C.free(unsafe.Pointer(cUri))
 
 	doc := C.poppler_document_new_from_file(cUri, nil, nil)
-	defer C.g_object_unref(C.gpointer(doc))
 	if doc == nil {
-		return false
+		return
 	}
+	defer C.g_object_unref(C.gpointer(doc))
 
 	for pageNum := range int(C.poppler_document_get_n_pages(doc)) {
 		page := C.poppler_document_get_page(doc, C.int(pageNum))
@@ -30,13 +64,8 @@ func PdfMatchesPattern(uri string, pattern string) bool {
 		pageText := C.GoString(cPageText)
 		C.free(unsafe.Pointer(cPageText))
 		C.g_object_unref(C.gpointer(page))
-		if strings.Contains(
-			strings.ToLower(pageText),
-			strings.ToLower(pattern)) {
-			return true
+		if !fn(pageText) {
+			return
 		}
-
 	}
-
-	return false
 }